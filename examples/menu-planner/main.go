@@ -97,15 +97,22 @@ func main() {
 	}
 
 	ctx := context.Background()
-	g := genkit.Init(ctx, genkit.WithPlugins(mistral.NewPlugin(apiKey)))
+	g := genkit.Init(ctx, genkit.WithPlugins(mistral.NewPlugin(apiKey,
+		mistral.WithModelAlias("creative-chat", "mistral-small-latest"),
+		mistral.WithDefaultConfig("creative-chat", mistralclient.CompletionConfig{
+			Temperature: 0.7,
+		}),
+		mistral.WithModelAlias("tool-chat", "mistral-medium-latest"),
+		mistral.WithDefaultConfig("tool-chat", mistralclient.CompletionConfig{
+			Temperature:       0.1,
+			ParallelToolCalls: true,
+		}),
+	)))
 
 	genkit.DefinePrompt(g, "recipePrompt",
 		ai.WithSystem(`You are a professional and experienced chef. You are creative and pragmatic.`),
 		ai.WithOutputType(Recipe{}),
-		ai.WithConfig(mistralclient.CompletionConfig{
-			Temperature: 0.7,
-		}),
-		ai.WithModelName("mistral/mistral-small-latest"),
+		ai.WithModelName("mistral/creative-chat"),
 		ai.WithPrompt(`Create a complete recipe according to the following instructions:
 {{instructions}}`))
 
@@ -181,11 +188,7 @@ Instructions:
 		ai.WithPrompt(`Add or update the grocery list with the following items:
 {{#each items}}- {{this}}{{/each}}
 `),
-		ai.WithModelName("mistral/mistral-medium-latest"),
-		ai.WithConfig(mistralclient.CompletionConfig{
-			Temperature:       0.1,
-			ParallelToolCalls: true,
-		}),
+		ai.WithModelName("mistral/tool-chat"),
 		ai.WithTools(
 			genkit.LookupTool(g, "groceryListGet"),
 			genkit.LookupTool(g, "groceryListAdd"),
@@ -209,10 +212,7 @@ Instructions:
 	})
 
 	genkit.DefinePrompt(g, "menuPrompt",
-		ai.WithModelName("mistral/mistral-small-latest"),
-		ai.WithConfig(mistralclient.CompletionConfig{
-			Temperature: 0.7,
-		}),
+		ai.WithModelName("mistral/creative-chat"),
 		ai.WithSystem(`You are a menu planner for an individual. Just give a list of courses without any details according the the constraints given by the user.
 Don't create the full recipe, just a short description of the meal. Examples:
 - Grilled Salmon with Quinoa and Steamed Broccoli
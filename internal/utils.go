@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -57,6 +58,11 @@ func init() {
 // The returned text will start with a capital letter and end with a period.
 // It can generate text longer than the source lorem ipsum text.
 func FakeText(wordCount int) (string, error) {
+	return FakeTextFrom(rand.New(rand.NewSource(time.Now().UnixNano())), wordCount)
+}
+
+// FakeTextFrom behaves like FakeText but draws randomness from the given generator.
+func FakeTextFrom(rng *rand.Rand, wordCount int) (string, error) {
 	if wordCount < 0 {
 		return "", fmt.Errorf("word count cannot be negative")
 	}
@@ -72,7 +78,7 @@ func FakeText(wordCount int) (string, error) {
 	}
 
 	var resultWords []string
-	startIndex := rand.Intn(numWords)
+	startIndex := rng.Intn(numWords)
 
 	for i := 0; i < wordCount; i++ {
 		resultWords = append(resultWords, loremIpsumWords[(startIndex+i)%numWords])
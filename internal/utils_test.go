@@ -1,6 +1,7 @@
 package internal_test
 
 import (
+	"math/rand"
 	"strings"
 	"testing"
 	"unicode"
@@ -157,3 +158,18 @@ func Test_GetSliceOrNil_ReturnsSliceWhenFound(t *testing.T) {
 	// Then
 	assert.Equal(t, []string{"a", "b", "c"}, result, "Expected zero value for non-existing key.")
 }
+
+func Test_FakeTextFrom_ShouldReturnSameText_WhenGeneratorsShareSeed(t *testing.T) {
+	// Given
+	rng1 := rand.New(rand.NewSource(42))
+	rng2 := rand.New(rand.NewSource(42))
+
+	// When
+	got1, err1 := internal.FakeTextFrom(rng1, 20)
+	got2, err2 := internal.FakeTextFrom(rng2, 20)
+
+	// Then
+	assert.NoError(t, err1)
+	assert.NoError(t, err2)
+	assert.Equal(t, got1, got2)
+}
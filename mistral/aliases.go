@@ -0,0 +1,133 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+type modelAlias struct {
+	alias  string
+	target string
+}
+
+// definedModel is a chat model defined by the plugin: a Mistral model, a virtual model or an alias.
+type definedModel struct {
+	name  string
+	model ai.Model
+	info  *ai.ModelInfo
+}
+
+// defineAlias defines a model forwarding the requests to the target, with the alias default config.
+func defineAlias(namespace, alias string, target definedModel, defaults *mistral.CompletionConfig) definedModel {
+	info := &ai.ModelInfo{
+		Label:    alias,
+		Stage:    target.info.Stage,
+		Supports: target.info.Supports,
+	}
+	model := ai.NewModel(
+		api.NewName(namespace, alias),
+		&ai.ModelOptions{
+			Label:    info.Label,
+			Stage:    info.Stage,
+			Supports: info.Supports,
+		},
+		func(ctx context.Context, mr *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			mr, err := withConfigDefaults(mr, defaults)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := target.model.Generate(ctx, mr, cb)
+			if err != nil {
+				return nil, err
+			}
+			if resp.Message != nil {
+				if resp.Message.Metadata == nil {
+					resp.Message.Metadata = make(map[string]any)
+				}
+				// A virtual target already tells which of its models answered.
+				if _, ok := resp.Message.Metadata[ResponseMetadataModel]; !ok {
+					resp.Message.Metadata[ResponseMetadataModel] = target.name
+				}
+			}
+			return resp, nil
+		},
+	)
+	return definedModel{name: alias, model: model, info: info}
+}
+
+// withConfigDefaults returns a copy of the request whose config is completed with the defaults, if any.
+func withConfigDefaults(mr *ai.ModelRequest, defaults *mistral.CompletionConfig) (*ai.ModelRequest, error) {
+	if defaults == nil {
+		return mr, nil
+	}
+	config, err := mergeConfig(*defaults, mr.Config)
+	if err != nil {
+		return nil, err
+	}
+	merged := *mr
+	merged.Config = config
+	return &merged, nil
+}
+
+// mergeConfig overrides the defaults with the config of a request: the non-zero fields of a typed config,
+// or the keys of a map config. The merged config keeps the type of the request one.
+func mergeConfig(defaults mistral.CompletionConfig, config any) (any, error) {
+	var overrides map[string]any
+	switch c := config.(type) {
+	case nil:
+		return defaults, nil
+	case *mistral.CompletionConfig:
+		if c == nil {
+			return defaults, nil
+		}
+		return mergeConfig(defaults, *c)
+	case mistral.CompletionConfig:
+		var err error
+		if overrides, err = configMap(c); err != nil {
+			return nil, err
+		}
+	case map[string]any:
+		overrides = c
+	default:
+		return nil, fmt.Errorf("unexpected config type: %T", config)
+	}
+
+	merged, err := configMap(defaults)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	if _, ok := config.(map[string]any); ok {
+		return merged, nil
+	}
+
+	b, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	var result mistral.CompletionConfig
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// configMap returns the non-zero fields of the config, by JSON name.
+func configMap(config mistral.CompletionConfig) (map[string]any, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]any)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,178 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGenerateWithModelAlias(t *testing.T) {
+	response := &mistralclient.ChatCompletionResponse{
+		Choices: []mistralclient.ChatCompletionChoice{
+			{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+		},
+	}
+
+	newPlugin := func(mockClient *mocks.MockClient) *mistral.Plugin {
+		return mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithModelAlias("chat-default", "mistral/mistral-small-latest"),
+			mistral.WithDefaultConfig("chat-default", mistralclient.CompletionConfig{Temperature: 0.7, MaxTokens: 100}),
+			mistral.WithDefaultConfig("mistral-small-latest", mistralclient.CompletionConfig{Temperature: 0.2, TopP: 0.9}))
+	}
+
+	t.Run("should forward the requests to the target with the merged configs", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, "mistral-small-latest", x.Model) &&
+						assert.Equal(t, 0.7, x.Temperature) &&
+						assert.Equal(t, 0.9, x.TopP) &&
+						assert.Equal(t, 50, x.MaxTokens)
+				}),
+			).
+			Return(response, nil)
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(newPlugin(mockClient)))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithConfig(mistralclient.CompletionConfig{MaxTokens: 50}),
+			ai.WithModelName("mistral/chat-default"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hello!", res.Text())
+		assert.Equal(t, "mistral-small-latest", res.Message.Metadata[mistral.ResponseMetadataModel])
+	})
+
+	t.Run("should override the defaults with the keys of a map config", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, 0.1, x.Temperature) &&
+						assert.Equal(t, 0.9, x.TopP) &&
+						assert.Equal(t, 100, x.MaxTokens)
+				}),
+			).
+			Return(response, nil)
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(newPlugin(mockClient)))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithConfig(map[string]any{"temperature": 0.1}),
+			ai.WithModelName("mistral/chat-default"))
+
+		// Then
+		require.NoError(t, err)
+	})
+
+	t.Run("should apply the defaults of the model to direct requests", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, 0.2, x.Temperature) && assert.Zero(t, x.MaxTokens)
+				}),
+			).
+			Return(response, nil)
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(newPlugin(mockClient)))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+	})
+
+	t.Run("should ignore the aliases to unknown models", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithModelAlias("chat-default", "mistral-unknown-latest"))
+
+		// When
+		g := genkit.Init(context.Background(), genkit.WithPlugins(p))
+
+		// Then
+		assert.Nil(t, genkit.LookupModel(g, "mistral/chat-default"))
+	})
+
+	t.Run("should keep the first default config of a model named with and without namespace", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, 0.2, x.Temperature)
+				}),
+			).
+			Return(response, nil).
+			Times(5)
+
+		for range 5 {
+			p := mistral.NewPlugin("fake",
+				mistral.WithClient(mockClient),
+				mistral.WithDefaultConfig("mistral/mistral-small-latest", mistralclient.CompletionConfig{Temperature: 0.2}),
+				mistral.WithDefaultConfig("mistral-small-latest", mistralclient.CompletionConfig{Temperature: 0.8}))
+
+			ctx := context.Background()
+			g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+			// When
+			_, err := genkit.Generate(ctx, g,
+				ai.WithPrompt("Hello"),
+				ai.WithModelName("mistral/mistral-small-latest"))
+
+			// Then
+			require.NoError(t, err)
+		}
+	})
+}
@@ -0,0 +1,320 @@
+package mistral
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// defaultReservedOutputTokens is the number of output tokens reserved for the completions without MaxTokens.
+const defaultReservedOutputTokens = 4096
+
+// BudgetScope defines what a budget is shared by.
+type BudgetScope int
+
+const (
+	// BudgetScopeKey shares the budget between every request made with the plugin API key.
+	BudgetScopeKey BudgetScope = iota
+
+	// BudgetScopeTenant gives each tenant its own budget (see WithTenant).
+	// Requests without tenant share the budget of the empty tenant.
+	BudgetScopeTenant
+)
+
+func (s BudgetScope) String() string {
+	if s == BudgetScopeTenant {
+		return "tenant"
+	}
+	return "key"
+}
+
+// Budget is a spending limit, in US dollars.
+type Budget struct {
+	// Limit is the maximum spend of the period.
+	Limit float64
+
+	// Period is the duration after which the spend is reset, 24h for a daily budget.
+	// The periods are aligned on time.Time.Truncate. Zero means the budget never resets.
+	Period time.Duration
+
+	Scope BudgetScope
+}
+
+// BudgetWarning is emitted when the spend of a budget crosses one of the warning thresholds.
+type BudgetWarning struct {
+	Budget    Budget
+	Key       string
+	Spent     float64
+	Threshold float64
+}
+
+// BudgetExceededError is returned when a request would exceed a budget. It matches ErrBudgetExceeded.
+type BudgetExceededError struct {
+	Budget    Budget
+	Key       string
+	Spent     float64
+	Estimated float64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s: %s budget %q of $%g, $%g already spent and $%g estimated for the request",
+		ErrBudgetExceeded, e.Budget.Scope, e.Key, e.Budget.Limit, e.Spent, e.Estimated)
+}
+
+func (e *BudgetExceededError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// BudgetStore persists the spend per budget key.
+type BudgetStore interface {
+	// Spent returns the amount spent for the key.
+	Spent(ctx context.Context, key string) (float64, error)
+
+	// Add adds the amount, possibly negative, to the spend of the key and returns the new spend.
+	Add(ctx context.Context, key string, amount float64) (float64, error)
+}
+
+// BudgetPolicy defines the budgets checked before each request.
+// The estimated cost of the request (prompt tokens and MaxTokens output tokens) is reserved before the call,
+// then reconciled with the actual usage.
+type BudgetPolicy struct {
+	Budgets []Budget
+
+	// ReservedOutputTokens is the number of output tokens reserved for the completions without MaxTokens.
+	// Defaults to 4096.
+	ReservedOutputTokens int
+
+	// WarnThresholds are the fractions of the limits (0.8 for 80%) emitting a warning when crossed.
+	WarnThresholds []float64
+
+	// OnWarning is called for each crossed threshold. By default, warnings are logged.
+	OnWarning func(ctx context.Context, w BudgetWarning)
+
+	// Store persists the spend. Defaults to an in-memory store.
+	Store BudgetStore
+}
+
+// MemoryBudgetStore keeps the spend in memory. It is safe for concurrent use.
+type MemoryBudgetStore struct {
+	mu    sync.Mutex
+	spent map[string]float64
+}
+
+func NewMemoryBudgetStore() *MemoryBudgetStore {
+	return &MemoryBudgetStore{spent: make(map[string]float64)}
+}
+
+func (s *MemoryBudgetStore) Spent(_ context.Context, key string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spent[key], nil
+}
+
+func (s *MemoryBudgetStore) Add(_ context.Context, key string, amount float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spent[key] += amount
+	return s.spent[key], nil
+}
+
+// FileBudgetStore keeps the spend in a JSON file, so it survives restarts.
+// It is safe for concurrent use within a process, not between processes.
+type FileBudgetStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileBudgetStore(path string) *FileBudgetStore {
+	return &FileBudgetStore{path: path}
+}
+
+func (s *FileBudgetStore) Spent(_ context.Context, key string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spent, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	return spent[key], nil
+}
+
+func (s *FileBudgetStore) Add(_ context.Context, key string, amount float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spent, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	spent[key] += amount
+
+	b, err := json.Marshal(spent)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(s.path, b, 0o600); err != nil {
+		return 0, fmt.Errorf("failed to save budget spend: %w", err)
+	}
+	return spent[key], nil
+}
+
+func (s *FileBudgetStore) load() (map[string]float64, error) {
+	spent := make(map[string]float64)
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return spent, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budget spend: %w", err)
+	}
+	if err := json.Unmarshal(b, &spent); err != nil {
+		return nil, fmt.Errorf("failed to read budget spend: %w", err)
+	}
+	return spent, nil
+}
+
+// budgetGuard checks and records the spend of the requests against the policy budgets.
+type budgetGuard struct {
+	// mu makes the check and the reservation of the estimated cost atomic.
+	mu sync.Mutex
+
+	policy BudgetPolicy
+	key    string
+	now    func() time.Time
+	logger *slog.Logger
+}
+
+func newBudgetGuard(policy BudgetPolicy, apiKey string, logger *slog.Logger) *budgetGuard {
+	if policy.Store == nil {
+		policy.Store = NewMemoryBudgetStore()
+	}
+	if policy.ReservedOutputTokens <= 0 {
+		policy.ReservedOutputTokens = defaultReservedOutputTokens
+	}
+	if policy.OnWarning == nil {
+		policy.OnWarning = func(ctx context.Context, w BudgetWarning) {
+			logger.WarnContext(ctx, "Budget threshold reached",
+				slog.String("scope", w.Budget.Scope.String()),
+				slog.String("key", w.Key),
+				slog.Float64("threshold", w.Threshold),
+				slog.Float64("limit", w.Budget.Limit),
+				slog.Float64("spent", w.Spent))
+		}
+	}
+
+	// The API key itself is never stored.
+	key := "default"
+	if apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		key = hex.EncodeToString(sum[:4])
+	}
+	return &budgetGuard{policy: policy, key: key, now: time.Now, logger: logger}
+}
+
+// storeKey returns the key of the budget spend for the current period.
+func (g *budgetGuard) storeKey(ctx context.Context, b Budget) (name, key string) {
+	name = g.key
+	if b.Scope == BudgetScopeTenant {
+		name = TenantFromContext(ctx)
+	}
+	key = b.Scope.String() + ":" + name
+	if b.Period > 0 {
+		key += ":" + strconv.FormatInt(g.now().Truncate(b.Period).Unix(), 10)
+	}
+	return name, key
+}
+
+// reserve checks the estimated cost fits in every budget and reserves it.
+// The returned function must be called with the actual cost once known, or zero if the request failed.
+func (g *budgetGuard) reserve(ctx context.Context, estimated float64) (func(actual float64), error) {
+	if g == nil {
+		return func(float64) {}, nil
+	}
+
+	type reservation struct {
+		budget Budget
+		name   string
+		key    string
+	}
+	// Concurrent requests would otherwise all pass the check before reserving anything.
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	reservations := make([]reservation, 0, len(g.policy.Budgets))
+	for _, b := range g.policy.Budgets {
+		name, key := g.storeKey(ctx, b)
+		spent, err := g.policy.Store.Spent(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if spent+estimated > b.Limit {
+			return nil, &BudgetExceededError{Budget: b, Key: name, Spent: spent, Estimated: estimated}
+		}
+		reservations = append(reservations, reservation{budget: b, name: name, key: key})
+	}
+
+	for i, r := range reservations {
+		if _, err := g.policy.Store.Add(ctx, r.key, estimated); err != nil {
+			for _, done := range reservations[:i] {
+				_, _ = g.policy.Store.Add(ctx, done.key, -estimated)
+			}
+			return nil, err
+		}
+	}
+
+	return func(actual float64) {
+		for _, r := range reservations {
+			spent, err := g.policy.Store.Add(ctx, r.key, actual-estimated)
+			if err != nil {
+				g.logger.ErrorContext(ctx, "Failed to record budget spend",
+					slog.String("budget", r.key), slog.Any("error", err))
+				continue
+			}
+			before := spent - actual
+			for _, t := range g.policy.WarnThresholds {
+				if limit := t * r.budget.Limit; before < limit && spent >= limit {
+					g.policy.OnWarning(ctx, BudgetWarning{Budget: r.budget, Key: r.name, Spent: spent, Threshold: t})
+				}
+			}
+		}
+	}, nil
+}
+
+// outputTokens returns the number of output tokens to reserve for a completion with the given MaxTokens.
+func (g *budgetGuard) outputTokens(maxTokens int) int {
+	if g == nil || maxTokens > 0 {
+		return maxTokens
+	}
+	return g.policy.ReservedOutputTokens
+}
+
+// reserveRequest reserves the estimated cost of a request to the model. Models without price are not limited.
+// The returned function must be called with the actual cost, or with known set to false to keep the estimation.
+func (g *budgetGuard) reserveRequest(
+	ctx context.Context, model string, prices PriceTable, inputTokens, outputTokens int,
+) (func(actual float64, known bool), error) {
+	price, ok := prices.Lookup(model)
+	if g == nil || !ok {
+		return func(float64, bool) {}, nil
+	}
+
+	estimated := price.Cost(model, inputTokens, outputTokens).Total
+	settle, err := g.reserve(ctx, estimated)
+	if err != nil {
+		return nil, err
+	}
+	return func(actual float64, known bool) {
+		if !known {
+			actual = estimated
+		}
+		settle(actual)
+	}, nil
+}
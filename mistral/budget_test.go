@@ -0,0 +1,257 @@
+package mistral_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGenerateWithBudget(t *testing.T) {
+	// Each completion costs $2: one million input and output tokens at $1 per million.
+	expensive := &mistralclient.ChatCompletionResponse{
+		Choices: []mistralclient.ChatCompletionChoice{
+			{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+		},
+		Usage: &mistralclient.UsageInfo{PromptTokens: 1_000_000, CompletionTokens: 1_000_000, TotalTokens: 2_000_000},
+	}
+	prices := mistral.WithPrices(mistral.PriceTable{"mistral-small": {Input: 1, Output: 1}, "mistral-embed": {Input: 1}})
+
+	generate := func(ctx context.Context, g *genkit.Genkit) error {
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello!"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		return err
+	}
+
+	t.Run("should reject requests once the budget is spent", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(expensive, nil).
+			Times(2)
+
+		var warnings []mistral.BudgetWarning
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), prices,
+			mistral.WithBudget(mistral.BudgetPolicy{
+				Budgets:        []mistral.Budget{{Limit: 3, Period: 24 * time.Hour}},
+				WarnThresholds: []float64{0.5, 1},
+				OnWarning: func(_ context.Context, w mistral.BudgetWarning) {
+					warnings = append(warnings, w)
+				},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		first := generate(ctx, g)
+		second := generate(ctx, g)
+		third := generate(ctx, g)
+
+		// Then
+		assert.NoError(t, first)
+		assert.NoError(t, second)
+		assert.ErrorIs(t, third, mistral.ErrBudgetExceeded)
+		var budgetErr *mistral.BudgetExceededError
+		require.True(t, errors.As(third, &budgetErr))
+		assert.InDelta(t, 4.0, budgetErr.Spent, 1e-6)
+
+		require.Len(t, warnings, 2)
+		assert.Equal(t, 0.5, warnings[0].Threshold)
+		assert.Equal(t, 1.0, warnings[1].Threshold)
+	})
+
+	t.Run("should reject a request whose estimated cost exceeds the budget", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), prices,
+			mistral.WithBudget(mistral.BudgetPolicy{Budgets: []mistral.Budget{{Limit: 0.5}}}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello!"),
+			ai.WithConfig(mistralclient.CompletionConfig{MaxTokens: 1_000_000}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.ErrorIs(t, err, mistral.ErrBudgetExceeded)
+	})
+
+	t.Run("should reserve the default output tokens without max tokens", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), prices,
+			mistral.WithBudget(mistral.BudgetPolicy{
+				Budgets:              []mistral.Budget{{Limit: 0.5}},
+				ReservedOutputTokens: 1_000_000,
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		err := generate(ctx, g)
+
+		// Then
+		assert.ErrorIs(t, err, mistral.ErrBudgetExceeded)
+	})
+
+	t.Run("should reserve the budget atomically between concurrent requests", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(expensive, nil).
+			Times(1)
+
+		// Each request reserves $1, so only one of them fits in the budget.
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), prices,
+			mistral.WithBudget(mistral.BudgetPolicy{
+				Budgets:              []mistral.Budget{{Limit: 1.5}},
+				ReservedOutputTokens: 1_000_000,
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		errs := make([]error, 10)
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = generate(ctx, g)
+			}()
+		}
+		wg.Wait()
+
+		// Then
+		var succeeded int
+		for _, err := range errs {
+			if err == nil {
+				succeeded++
+			} else {
+				assert.ErrorIs(t, err, mistral.ErrBudgetExceeded)
+			}
+		}
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("should give each tenant its own budget", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(expensive, nil).
+			Times(2)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), prices,
+			mistral.WithBudget(mistral.BudgetPolicy{
+				Budgets: []mistral.Budget{{Limit: 1, Scope: mistral.BudgetScopeTenant}},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		acme := generate(mistral.WithTenant(ctx, "acme"), g)
+		globex := generate(mistral.WithTenant(ctx, "globex"), g)
+		acmeAgain := generate(mistral.WithTenant(ctx, "acme"), g)
+
+		// Then
+		assert.NoError(t, acme)
+		assert.NoError(t, globex)
+		assert.ErrorIs(t, acmeAgain, mistral.ErrBudgetExceeded)
+		assert.ErrorContains(t, acmeAgain, `tenant budget "acme"`)
+	})
+
+	t.Run("should check the budget before computing embeddings", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithEmbedding(mockClient)
+
+		store := mistral.NewMemoryBudgetStore()
+		// Without API key, the key budget is stored under "key:default".
+		p := mistral.NewPlugin("", mistral.WithClient(mockClient), prices,
+			mistral.WithBudget(mistral.BudgetPolicy{Budgets: []mistral.Budget{{Limit: 1}}, Store: store}))
+
+		ctx := context.Background()
+		_, err := store.Add(ctx, "key:default", 1)
+		require.NoError(t, err)
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err = genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("Hello!", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+
+		// Then
+		assert.ErrorIs(t, err, mistral.ErrBudgetExceeded)
+	})
+}
+
+func TestFileBudgetStore(t *testing.T) {
+	t.Run("should persist the spend", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		path := filepath.Join(t.TempDir(), "budget.json")
+		_, err := mistral.NewFileBudgetStore(path).Add(ctx, "key:default", 1.5)
+		require.NoError(t, err)
+
+		// When
+		spent, err := mistral.NewFileBudgetStore(path).Add(ctx, "key:default", 0.5)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 2.0, spent)
+	})
+
+	t.Run("should return zero for a missing file", func(t *testing.T) {
+		// When
+		spent, err := mistral.NewFileBudgetStore(filepath.Join(t.TempDir(), "none.json")).
+			Spent(context.Background(), "key:default")
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 0.0, spent)
+	})
+}
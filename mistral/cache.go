@@ -0,0 +1,360 @@
+package mistral
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+const (
+	defaultCacheSize = 1000
+
+	// ResponseMetadataCacheHit is the response message metadata key set to true
+	// when the response comes from the cache instead of Mistral.
+	ResponseMetadataCacheHit = "cacheHit"
+
+	// EmbeddingMetadataCacheHit is the embedding metadata key set to true
+	// when the embedding comes from the cache instead of Mistral.
+	EmbeddingMetadataCacheHit = "cacheHit"
+
+	// ConfigNoCache is the key of map configs (e.g. from prompt files) bypassing the cache when set to true.
+	ConfigNoCache = "noCache"
+)
+
+// Cache stores the responses of Mistral, keyed by a hash of the request.
+type Cache interface {
+	// Get returns the value of the key, or false when it is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set stores the value of the key for the ttl. Zero means the value never expires.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// CachePolicy defines how the responses of Mistral are cached.
+// Chat completions are cached by request, embeddings by input text, so that only the missing texts
+// of a batch are sent to Mistral. Cache hits aren't charged to the budgets and cost nothing.
+type CachePolicy struct {
+	// Store keeps the responses. Defaults to an in-memory LRU cache of 1000 entries.
+	Store Cache
+
+	// TTL is the duration the responses are kept. Zero means they never expire.
+	TTL time.Duration
+}
+
+type noCacheKey struct{}
+
+// WithoutCache returns a context whose requests bypass the cache: they are neither read from nor written to it.
+// Requests with a map config can set ConfigNoCache instead.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// cacheBypassed reports whether the context or the config of a request bypass the cache.
+func cacheBypassed(ctx context.Context, config any) bool {
+	if bypass, _ := ctx.Value(noCacheKey{}).(bool); bypass {
+		return true
+	}
+	m, ok := config.(map[string]any)
+	if !ok {
+		return false
+	}
+	bypass, _ := m[ConfigNoCache].(bool)
+	return bypass
+}
+
+// LRUCache keeps the most recently used entries in memory. It is safe for concurrent use.
+type LRUCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache returns a cache evicting the least recently used entries beyond size.
+func NewLRUCache(size int) *LRUCache {
+	if size <= 0 {
+		size = defaultCacheSize
+	}
+	return &LRUCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *LRUCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+func (c *LRUCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// DiskCache keeps the entries as files of a directory, so they survive restarts.
+// Expired entries are removed when read. It is safe for concurrent use.
+type DiskCache struct {
+	dir string
+}
+
+type diskEntry struct {
+	Value   []byte    `json:"value"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// NewDiskCache returns a cache storing its entries in dir, created when needed.
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{dir: dir}
+}
+
+func (c *DiskCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	path := c.path(key)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	var entry diskEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, false, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	if !entry.Expires.IsZero() && time.Now().After(entry.Expires) {
+		_ = os.Remove(path)
+		return nil, false, nil
+	}
+	return entry.Value, true, nil
+}
+
+func (c *DiskCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	entry := diskEntry{Value: value}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// The entry is written to a temporary file first, so that readers never see a partial entry.
+	tmp, err := os.CreateTemp(c.dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, strings.ReplaceAll(key, ":", "-")+".json")
+}
+
+// responseCache reads and writes the responses of Mistral with the policy store.
+// A nil responseCache calls Mistral directly.
+type responseCache struct {
+	store  Cache
+	ttl    time.Duration
+	logger *slog.Logger
+}
+
+func newResponseCache(policy CachePolicy, logger *slog.Logger) *responseCache {
+	if policy.Store == nil {
+		policy.Store = NewLRUCache(defaultCacheSize)
+	}
+	return &responseCache{store: policy.Store, ttl: policy.TTL, logger: logger}
+}
+
+// cacheKey hashes the JSON encoding of the request, which is canonical: struct fields keep their order
+// and map keys are sorted.
+func cacheKey(kind string, req any) (string, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return kind + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// chatKey returns the value identifying a chat completion request: the request and its complete JSON schemas.
+func chatKey(ctx context.Context, req *mistral.ChatCompletionRequest) any {
+	return struct {
+		Request *mistral.ChatCompletionRequest `json:"request"`
+		Schemas *mapping.Schemas               `json:"schemas,omitempty"`
+	}{req, schemasFrom(ctx)}
+}
+
+// chat returns the cached response of the request, or calls complete and caches its response.
+// Streamed and non-streamed requests share their responses.
+func (c *responseCache) chat(
+	ctx context.Context, req *mistral.ChatCompletionRequest, bypass bool,
+	complete func() (*mistral.ChatCompletionResponse, error),
+) (*mistral.ChatCompletionResponse, bool, error) {
+	if c == nil || bypass {
+		resp, err := complete()
+		return resp, false, err
+	}
+
+	keyed := *req
+	keyed.Stream = false
+	key, err := cacheKey("chat", chatKey(ctx, &keyed))
+	if err != nil {
+		c.logger.WarnContext(ctx, "Failed to compute the cache key", slog.Any("error", err))
+		resp, err := complete()
+		return resp, false, err
+	}
+
+	if b, ok := c.get(ctx, key); ok {
+		var resp mistral.ChatCompletionResponse
+		if err := json.Unmarshal(b, &resp); err == nil {
+			c.logger.DebugContext(ctx, "Cache hit", slog.String("model", req.Model), slog.String("key", key))
+			return &resp, true, nil
+		}
+		c.logger.WarnContext(ctx, "Invalid cache entry, ignored", slog.String("key", key))
+	}
+
+	resp, err := complete()
+	if err != nil {
+		return nil, false, err
+	}
+	if b, err := json.Marshal(resp); err == nil {
+		c.set(ctx, key, b)
+	}
+	return resp, false, nil
+}
+
+// embeddings returns the cached embeddings of the request inputs, and calls embed with the missing ones only.
+// The usage of the returned response only counts the embedded inputs. hits tells which inputs come from the cache.
+func (c *responseCache) embeddings(
+	ctx context.Context, req *mistral.EmbeddingRequest, bypass bool,
+	embed func(*mistral.EmbeddingRequest) (*mistral.EmbeddingResponse, error),
+) (resp *mistral.EmbeddingResponse, hits []bool, err error) {
+	if c == nil || bypass {
+		resp, err := embed(req)
+		return resp, nil, err
+	}
+
+	keys := make([]string, len(req.Input))
+	vectors := make([]mistral.EmbeddingVector, len(req.Input))
+	hits = make([]bool, len(req.Input))
+	missing := *req
+	missing.Input = nil
+	var misses []int
+	for i, input := range req.Input {
+		item := *req
+		item.Input = []string{input}
+		if keys[i], err = cacheKey("embeddings", &item); err != nil {
+			return nil, nil, err
+		}
+		if b, ok := c.get(ctx, keys[i]); ok && json.Unmarshal(b, &vectors[i]) == nil {
+			hits[i] = true
+			continue
+		}
+		misses = append(misses, i)
+		missing.Input = append(missing.Input, input)
+	}
+
+	resp = &mistral.EmbeddingResponse{Model: req.Model}
+	if len(misses) > 0 {
+		if resp, err = embed(&missing); err != nil {
+			return nil, nil, err
+		}
+		if len(resp.Data) != len(misses) {
+			if len(misses) == len(req.Input) {
+				// Nothing to merge: the response is returned as is.
+				return resp, hits, nil
+			}
+			return nil, nil, fmt.Errorf("%w: %d embeddings for %d inputs", ErrNoEmbeddings, len(resp.Data), len(misses))
+		}
+		for j, data := range resp.Data {
+			i := misses[j]
+			vectors[i] = data.Embedding
+			if b, err := json.Marshal(data.Embedding); err == nil {
+				c.set(ctx, keys[i], b)
+			}
+		}
+	}
+
+	merged := *resp
+	merged.Data = make([]mistral.EmbeddingData, len(vectors))
+	for i, vector := range vectors {
+		merged.Data[i] = mistral.EmbeddingData{Object: "embedding", Embedding: vector, Index: i}
+	}
+	c.logger.DebugContext(ctx, "Embeddings cache lookup",
+		slog.String("model", req.Model), slog.Int("hits", len(req.Input)-len(misses)), slog.Int("misses", len(misses)))
+	return &merged, hits, nil
+}
+
+// get reads the cache. Failures are logged and considered as misses.
+func (c *responseCache) get(ctx context.Context, key string) ([]byte, bool) {
+	b, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		c.logger.WarnContext(ctx, "Failed to read the cache", slog.String("key", key), slog.Any("error", err))
+		return nil, false
+	}
+	return b, ok
+}
+
+// set writes the cache. Failures are logged: the response is still returned.
+func (c *responseCache) set(ctx context.Context, key string, value []byte) {
+	if err := c.store.Set(ctx, key, value, c.ttl); err != nil {
+		c.logger.WarnContext(ctx, "Failed to write the cache", slog.String("key", key), slog.Any("error", err))
+	}
+}
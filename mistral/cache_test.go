@@ -0,0 +1,273 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should evict the least recently used entries", func(t *testing.T) {
+		// Given
+		c := mistral.NewLRUCache(2)
+		require.NoError(t, c.Set(ctx, "a", []byte("1"), 0))
+		require.NoError(t, c.Set(ctx, "b", []byte("2"), 0))
+		_, _, _ = c.Get(ctx, "a")
+
+		// When
+		require.NoError(t, c.Set(ctx, "c", []byte("3"), 0))
+
+		// Then
+		_, ok, _ := c.Get(ctx, "b")
+		assert.False(t, ok)
+		v, ok, _ := c.Get(ctx, "a")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), v)
+	})
+
+	t.Run("should expire the entries after their ttl", func(t *testing.T) {
+		// Given
+		c := mistral.NewLRUCache(2)
+		require.NoError(t, c.Set(ctx, "a", []byte("1"), time.Millisecond))
+
+		// When
+		time.Sleep(5 * time.Millisecond)
+		_, ok, err := c.Get(ctx, "a")
+
+		// Then
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestDiskCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should keep the entries between instances", func(t *testing.T) {
+		// Given
+		dir := t.TempDir()
+		require.NoError(t, mistral.NewDiskCache(dir).Set(ctx, "chat:abc", []byte(`{"id":"1"}`), time.Hour))
+
+		// When
+		v, ok, err := mistral.NewDiskCache(dir).Get(ctx, "chat:abc")
+
+		// Then
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(`{"id":"1"}`), v)
+	})
+
+	t.Run("should expire the entries after their ttl", func(t *testing.T) {
+		// Given
+		c := mistral.NewDiskCache(t.TempDir())
+		require.NoError(t, c.Set(ctx, "chat:abc", []byte("1"), time.Millisecond))
+
+		// When
+		time.Sleep(5 * time.Millisecond)
+		_, ok, err := c.Get(ctx, "chat:abc")
+
+		// Then
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestGenerateWithCache(t *testing.T) {
+	completion := &mistralclient.ChatCompletionResponse{
+		Id: "1",
+		Choices: []mistralclient.ChatCompletionChoice{
+			{Message: mistralclient.NewAssistantMessageFromString("Paris"), FinishReason: mistralclient.FinishReasonStop},
+		},
+		Usage: &mistralclient.UsageInfo{PromptTokens: 10, CompletionTokens: 1, TotalTokens: 11},
+	}
+
+	t.Run("should answer identical requests from the cache", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(completion, nil).
+			Times(1)
+
+		tracker := mistral.NewCostTracker()
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithCostTracker(tracker),
+			mistral.WithCache(mistral.CachePolicy{TTL: time.Hour}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		generate := func() (*ai.ModelResponse, error) {
+			return genkit.Generate(ctx, g,
+				ai.WithPrompt("What is the capital of France?"),
+				ai.WithModelName("mistral/mistral-small-latest"))
+		}
+
+		// When
+		first, err := generate()
+		require.NoError(t, err)
+		second, err := generate()
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Paris", second.Text())
+		assert.NotContains(t, first.Message.Metadata, mistral.ResponseMetadataCacheHit)
+		assert.Equal(t, true, second.Message.Metadata[mistral.ResponseMetadataCacheHit])
+		assert.Zero(t, second.Usage.InputTokens)
+		firstCost, _ := mistral.ResponseCost(first)
+		assert.Positive(t, firstCost.Total)
+		assert.InDelta(t, firstCost.Total, tracker.Total(mistral.CostFilter{}), 1e-12)
+	})
+
+	t.Run("should bypass the cache", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(completion, nil).
+			Times(3)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithCache(mistral.CachePolicy{}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		require.NoError(t, err)
+		byContext, err := genkit.Generate(mistral.WithoutCache(ctx), g,
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		require.NoError(t, err)
+		byConfig, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithConfig(map[string]any{mistral.ConfigNoCache: true}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.NotContains(t, byContext.Message.Metadata, mistral.ResponseMetadataCacheHit)
+		assert.NotContains(t, byConfig.Message.Metadata, mistral.ResponseMetadataCacheHit)
+	})
+
+	t.Run("should stream a cached response as a single chunk", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(completion, nil).
+			Times(1)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithCache(mistral.CachePolicy{Store: mistral.NewDiskCache(t.TempDir())}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		require.NoError(t, err)
+
+		// When
+		var chunks []string
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"),
+			ai.WithStreaming(func(_ context.Context, chunk *ai.ModelResponseChunk) error {
+				chunks = append(chunks, chunk.Text())
+				return nil
+			}))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Paris"}, chunks)
+		assert.Equal(t, true, res.Message.Metadata[mistral.ResponseMetadataCacheHit])
+	})
+}
+
+func TestEmbedWithCache(t *testing.T) {
+	t.Run("should only embed the texts missing from the cache", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithEmbedding(mockClient)
+
+		gomock.InOrder(
+			mockClient.EXPECT().
+				Embeddings(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Eq(&mistralclient.EmbeddingRequest{Model: "mistral-embed", Input: []string{"first"}}),
+				).
+				Return(&mistralclient.EmbeddingResponse{
+					Data:  []mistralclient.EmbeddingData{{Embedding: []float32{1, 1}}},
+					Usage: mistralclient.UsageInfo{PromptTokens: 1, TotalTokens: 1},
+				}, nil),
+			mockClient.EXPECT().
+				Embeddings(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Eq(&mistralclient.EmbeddingRequest{Model: "mistral-embed", Input: []string{"second"}}),
+				).
+				Return(&mistralclient.EmbeddingResponse{
+					Data:  []mistralclient.EmbeddingData{{Embedding: []float32{2, 2}}},
+					Usage: mistralclient.UsageInfo{PromptTokens: 1, TotalTokens: 1},
+				}, nil),
+		)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithCache(mistral.CachePolicy{}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		_, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("first", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+		require.NoError(t, err)
+
+		// When
+		res, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("first", nil), ai.DocumentFromText("second", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+
+		// Then
+		require.NoError(t, err)
+		require.Len(t, res.Embeddings, 2)
+		assert.Equal(t, []float32{1, 1}, res.Embeddings[0].Embedding)
+		assert.Equal(t, true, res.Embeddings[0].Metadata[mistral.EmbeddingMetadataCacheHit])
+		hitCost, _ := mistral.EmbeddingCost(res.Embeddings[0])
+		assert.Zero(t, hitCost.Total)
+		assert.Equal(t, []float32{2, 2}, res.Embeddings[1].Embedding)
+		assert.NotContains(t, res.Embeddings[1].Metadata, mistral.EmbeddingMetadataCacheHit)
+		missCost, _ := mistral.EmbeddingCost(res.Embeddings[1])
+		assert.Positive(t, missCost.Total)
+	})
+}
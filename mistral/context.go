@@ -0,0 +1,214 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+const (
+	// MessageMetadataPinned is the message metadata key keeping a message, and its turn, in the context window.
+	MessageMetadataPinned = "pinned"
+
+	defaultReservedTokens = 1024
+	defaultSummaryTokens  = 512
+	summaryInstructions   = "Summarize the following conversation between a user and an assistant. " +
+		"Keep the facts, decisions and tool results needed to continue the conversation. Answer with the summary only."
+	summaryMessagePrefix = "Summary of the earlier conversation:\n"
+)
+
+var ErrContextWindowExceeded = errors.New("request exceeds the model context window")
+
+// ContextWindowPolicy defines how requests exceeding the model context window are shortened.
+// The oldest turns are dropped first. System messages, the last turn and the turns holding a pinned message
+// (see MessageMetadataPinned) are always kept. A turn starts with a user message and holds the following
+// model and tool messages, so tool requests and responses are never split.
+type ContextWindowPolicy struct {
+	// MaxContextTokens overrides the context length given by the model card.
+	MaxContextTokens int
+
+	// ReservedTokens is the number of tokens kept for the response when the request doesn't set MaxTokens.
+	// Defaults to 1024.
+	ReservedTokens int
+
+	// SummaryModel is the Mistral model used to summarize the dropped turns.
+	// When empty, the dropped turns are not summarized.
+	SummaryModel string
+
+	// SummaryMaxTokens is the maximum length of the summary. Defaults to 512.
+	SummaryMaxTokens int
+}
+
+// turn is a range of messages dropped or kept together.
+type turn struct {
+	messages []*ai.Message
+	pinned   bool
+}
+
+// fitContextWindow drops, and optionally summarizes, the oldest turns until the request fits in the context window
+// of the model config. The request is returned unchanged when it already fits.
+func fitContextWindow(
+	ctx context.Context, c mistral.Client, mc modelConfig, tok tokens.Tokenizer, mr *ai.ModelRequest,
+	cfg *mistral.CompletionConfig,
+) (*ai.ModelRequest, error) {
+	policy, contextLength := *mc.contextWindow, mc.contextLength
+	if policy.MaxContextTokens > 0 {
+		contextLength = policy.MaxContextTokens
+	}
+	if contextLength <= 0 {
+		return mr, nil
+	}
+
+	reserved := policy.ReservedTokens
+	if reserved <= 0 {
+		reserved = defaultReservedTokens
+	}
+	if cfg != nil && cfg.MaxTokens > 0 {
+		reserved = cfg.MaxTokens
+	}
+	limit := contextLength - reserved
+
+	if tok.Request(mr) <= limit {
+		return mr, nil
+	}
+
+	summaryTokens := 0
+	if policy.SummaryModel != "" {
+		summaryTokens = policy.SummaryMaxTokens
+		if summaryTokens <= 0 {
+			summaryTokens = defaultSummaryTokens
+		}
+	}
+
+	system, turns := splitTurns(mr.Messages)
+	total := tok.Request(mr) + summaryTokens
+	var dropped []*ai.Message
+	kept := make([]turn, 0, len(turns))
+	for i, t := range turns {
+		if total > limit && !t.pinned && i < len(turns)-1 {
+			for _, msg := range t.messages {
+				total -= tok.Message(msg)
+			}
+			dropped = append(dropped, t.messages...)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	if total > limit {
+		return nil, fmt.Errorf("%w: about %d tokens for %d available", ErrContextWindowExceeded, total, limit)
+	}
+
+	messages := append([]*ai.Message{}, system...)
+	if policy.SummaryModel != "" && len(dropped) > 0 {
+		summary, err := summarize(ctx, c, mc, tok, policy.SummaryModel, summaryTokens, dropped)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, ai.NewSystemTextMessage(summaryMessagePrefix+summary))
+	}
+	for _, t := range kept {
+		messages = append(messages, t.messages...)
+	}
+
+	shortened := *mr
+	shortened.Messages = messages
+	return &shortened, nil
+}
+
+// splitTurns returns the leading system messages and the turns of the conversation.
+func splitTurns(messages []*ai.Message) ([]*ai.Message, []turn) {
+	var system []*ai.Message
+	i := 0
+	for ; i < len(messages) && messages[i].Role == ai.RoleSystem; i++ {
+		system = append(system, messages[i])
+	}
+
+	var turns []turn
+	for _, msg := range messages[i:] {
+		if msg.Role == ai.RoleUser || len(turns) == 0 {
+			turns = append(turns, turn{})
+		}
+		t := &turns[len(turns)-1]
+		t.messages = append(t.messages, msg)
+		if pinned, _ := msg.Metadata[MessageMetadataPinned].(bool); pinned {
+			t.pinned = true
+		}
+	}
+	return system, turns
+}
+
+// summarize asks the summary model to summarize the messages.
+// Like the other calls, it is checked against the budgets, goes through the hooks and has its cost recorded.
+func summarize(
+	ctx context.Context, c mistral.Client, mc modelConfig, tok tokens.Tokenizer, model string, maxTokens int,
+	messages []*ai.Message,
+) (string, error) {
+	mr := &ai.ModelRequest{
+		Messages: []*ai.Message{
+			ai.NewSystemTextMessage(summaryInstructions),
+			ai.NewUserTextMessage(transcript(messages)),
+		},
+	}
+	req, _, err := mapping.MapRequestToMistral(model, mr, &mistral.CompletionConfig{MaxTokens: maxTokens})
+	if err != nil {
+		return "", err
+	}
+
+	settle, err := mc.budget.reserveRequest(ctx, model, mc.prices, tok.Request(mr), maxTokens)
+	if err != nil {
+		return "", err
+	}
+
+	call := &Call{Operation: OperationChat, ModelRequest: mr, ChatRequest: req}
+	send := func(ctx context.Context, call *Call) error {
+		var err error
+		call.ChatResponse, err = c.ChatCompletion(ctx, call.ChatRequest)
+		return err
+	}
+	mapResponse := func(call *Call) error {
+		if len(call.ChatResponse.Choices) == 0 {
+			return errors.New("empty response")
+		}
+		var err error
+		call.ModelResponse, err = mapping.MapToGenkitResponse(call.ModelRequest, call.ChatResponse)
+		return err
+	}
+	if err := mc.hooks.intercept(ctx, call, send, mapResponse); err != nil {
+		settle(0, true)
+		return "", fmt.Errorf("failed to summarize the conversation: %w", err)
+	}
+
+	cost, ok := setResponseCost(call.ModelResponse, model, mc.prices)
+	if ok && mc.costs != nil {
+		mc.costs.Record(ctx, cost)
+	}
+	settle(cost.Total, ok)
+	return call.ModelResponse.Text(), nil
+}
+
+// transcript renders the messages as plain text for the summary model.
+func transcript(messages []*ai.Message) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		for _, part := range msg.Content {
+			switch {
+			case part.IsText():
+				fmt.Fprintf(&sb, "%s: %s\n", msg.Role, part.Text)
+			case part.IsToolRequest():
+				input, _ := json.Marshal(part.ToolRequest.Input)
+				fmt.Fprintf(&sb, "%s: called tool %s with %s\n", msg.Role, part.ToolRequest.Name, input)
+			case part.IsToolResponse():
+				output, _ := json.Marshal(part.ToolResponse.Output)
+				fmt.Fprintf(&sb, "%s: tool %s returned %s\n", msg.Role, part.ToolResponse.Name, output)
+			}
+		}
+	}
+	return sb.String()
+}
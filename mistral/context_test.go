@@ -0,0 +1,261 @@
+package mistral_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGenerateWithContextWindow(t *testing.T) {
+	long := strings.Repeat("cat ", 100)
+
+	conversation := func(pinFirst bool) []*ai.Message {
+		first := ai.NewUserTextMessage("first " + long)
+		if pinFirst {
+			first.Metadata = map[string]any{mistral.MessageMetadataPinned: true}
+		}
+		return []*ai.Message{
+			ai.NewSystemTextMessage("Be nice"),
+			first,
+			ai.NewModelTextMessage(long),
+			ai.NewUserTextMessage("second " + long),
+			ai.NewModelTextMessage("ok"),
+			ai.NewUserTextMessage("last"),
+		}
+	}
+
+	texts := func(messages []mistralclient.ChatMessage) []string {
+		res := make([]string, 0, len(messages))
+		for _, m := range messages {
+			res = append(res, strings.Fields(m.Content().String())[0])
+		}
+		return res
+	}
+
+	hello := &mistralclient.ChatCompletionResponse{
+		Choices: []mistralclient.ChatCompletionChoice{
+			{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		policy   mistral.ContextWindowPolicy
+		pinFirst bool
+		expected []string
+	}{
+		{
+			name:     "should keep the request unchanged when it fits",
+			policy:   mistral.ContextWindowPolicy{MaxContextTokens: 1000, ReservedTokens: 50},
+			expected: []string{"Be", "first", "cat", "second", "ok", "last"},
+		},
+		{
+			name:     "should drop the oldest turns",
+			policy:   mistral.ContextWindowPolicy{MaxContextTokens: 300, ReservedTokens: 50},
+			expected: []string{"Be", "second", "ok", "last"},
+		},
+		{
+			name:     "should keep the pinned turns",
+			policy:   mistral.ContextWindowPolicy{MaxContextTokens: 300, ReservedTokens: 50},
+			pinFirst: true,
+			expected: []string{"Be", "first", "cat", "last"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			ctrl := gomock.NewController(t)
+			mockClient := mocks.NewMockClient(ctrl)
+
+			setupListModelWithChatCompletion(mockClient)
+
+			mockClient.EXPECT().
+				ChatCompletion(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+						return assert.Equal(t, tc.expected, texts(x.Messages))
+					}),
+				).
+				Return(hello, nil)
+
+			p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithContextWindow(tc.policy))
+
+			ctx := context.Background()
+			g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+			// When
+			res, err := genkit.Generate(ctx, g,
+				ai.WithMessages(conversation(tc.pinFirst)...),
+				ai.WithModelName("mistral/mistral-small-latest"))
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, "Hello!", res.Text())
+		})
+	}
+
+	t.Run("should summarize the dropped turns", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		gomock.InOrder(
+			mockClient.EXPECT().
+				ChatCompletion(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+						return assert.Equal(t, "ministral-3b-latest", x.Model) &&
+							assert.Equal(t, 50, x.MaxTokens) &&
+							assert.Contains(t, x.Messages[1].Content().String(), "user: first cat")
+					}),
+				).
+				Return(&mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{Message: mistralclient.NewAssistantMessageFromString("They talked about cats.")},
+					},
+				}, nil),
+			mockClient.EXPECT().
+				ChatCompletion(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+						return assert.Equal(t, "mistral-small-latest", x.Model) &&
+							assert.Equal(t, []string{"Be", "Summary", "second", "ok", "last"}, texts(x.Messages)) &&
+							assert.Contains(t, x.Messages[1].Content().String(), "They talked about cats.")
+					}),
+				).
+				Return(hello, nil),
+		)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithContextWindow(mistral.ContextWindowPolicy{
+				MaxContextTokens: 300,
+				ReservedTokens:   50,
+				SummaryModel:     "ministral-3b-latest",
+				SummaryMaxTokens: 50,
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(conversation(false)...),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello!", res.Text())
+	})
+
+	t.Run("should record the cost of the summary", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		gomock.InOrder(
+			mockClient.EXPECT().
+				ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(&mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{Message: mistralclient.NewAssistantMessageFromString("They talked about cats.")},
+					},
+					Usage: &mistralclient.UsageInfo{PromptTokens: 1_000_000, CompletionTokens: 0, TotalTokens: 1_000_000},
+				}, nil),
+			mockClient.EXPECT().
+				ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(hello, nil),
+		)
+
+		tracker := mistral.NewCostTracker()
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithCostTracker(tracker),
+			mistral.WithPrices(mistral.PriceTable{"ministral-3b": {Input: 1, Output: 1}}),
+			mistral.WithContextWindow(mistral.ContextWindowPolicy{
+				MaxContextTokens: 300,
+				ReservedTokens:   50,
+				SummaryModel:     "ministral-3b-latest",
+				SummaryMaxTokens: 50,
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithMessages(conversation(false)...),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.InDelta(t, 1.0, tracker.Total(mistral.CostFilter{Model: "ministral-3b-latest"}), 1e-6)
+	})
+
+	t.Run("should check the summary against the budget", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		// Each token of the summary model costs $1.
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithPrices(mistral.PriceTable{"ministral-3b": {Input: 1_000_000, Output: 1_000_000}}),
+			mistral.WithBudget(mistral.BudgetPolicy{Budgets: []mistral.Budget{{Limit: 10}}}),
+			mistral.WithContextWindow(mistral.ContextWindowPolicy{
+				MaxContextTokens: 300,
+				ReservedTokens:   50,
+				SummaryModel:     "ministral-3b-latest",
+				SummaryMaxTokens: 50,
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithMessages(conversation(false)...),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.ErrorIs(t, err, mistral.ErrBudgetExceeded)
+	})
+
+	t.Run("should return an error when the last turn doesn't fit", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithContextWindow(mistral.ContextWindowPolicy{MaxContextTokens: 100, ReservedTokens: 50}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt(long),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorIs(t, err, mistral.ErrContextWindowExceeded)
+		assert.ErrorIs(t, err, mistral.ErrInvalidModelInput)
+	})
+}
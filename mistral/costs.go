@@ -0,0 +1,181 @@
+package mistral
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+type tenantKey struct{}
+
+// WithTenant returns a context attributing the costs of the requests made with it to the tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set with WithTenant, or an empty string.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// CostEntry is a cost recorded by a CostTracker.
+type CostEntry struct {
+	Time   time.Time
+	Flow   string
+	Tenant string
+	Cost   Cost
+}
+
+// CostFilter selects cost entries. Zero fields match every entry.
+type CostFilter struct {
+	// Since and Until bound the time window, Until excluded.
+	Since time.Time
+	Until time.Time
+
+	Flow   string
+	Tenant string
+	Model  string
+}
+
+func (f CostFilter) match(e CostEntry) bool {
+	return (f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until)) &&
+		(f.Flow == "" || f.Flow == e.Flow) &&
+		(f.Tenant == "" || f.Tenant == e.Tenant) &&
+		(f.Model == "" || f.Model == e.Cost.Model)
+}
+
+// defaultMaxCostEntries is the default number of entries kept by a CostTracker.
+const defaultMaxCostEntries = 100_000
+
+// CostTracker aggregates the costs of the requests. It is safe for concurrent use.
+// It keeps the last 100,000 entries by default, see WithMaxCostEntries and WithCostRetention.
+type CostTracker struct {
+	mu         sync.Mutex
+	entries    []CostEntry
+	now        func() time.Time
+	maxEntries int
+	retention  time.Duration
+}
+
+// CostTrackerOption configures a CostTracker.
+type CostTrackerOption func(*CostTracker)
+
+// WithMaxCostEntries sets the number of entries kept by the tracker. The oldest ones are dropped first.
+// Zero or less keeps every entry.
+func WithMaxCostEntries(n int) CostTrackerOption {
+	return func(t *CostTracker) {
+		t.maxEntries = n
+	}
+}
+
+// WithCostRetention sets the duration the entries are kept. Zero means they are kept until dropped by the size limit.
+func WithCostRetention(d time.Duration) CostTrackerOption {
+	return func(t *CostTracker) {
+		t.retention = d
+	}
+}
+
+func NewCostTracker(opts ...CostTrackerOption) *CostTracker {
+	t := &CostTracker{now: time.Now, maxEntries: defaultMaxCostEntries}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Record adds a cost, attributed to the flow and the tenant found in the context.
+func (t *CostTracker) Record(ctx context.Context, cost Cost) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.entries = append(t.entries, CostEntry{
+		Time:   now,
+		Flow:   core.FlowNameFromContext(ctx),
+		Tenant: TenantFromContext(ctx),
+		Cost:   cost,
+	})
+	t.prune(now)
+}
+
+// prune drops the expired entries and the oldest ones above the size limit.
+// The entries are recorded in time order, so the dropped ones are at the beginning.
+func (t *CostTracker) prune(now time.Time) {
+	drop := 0
+	if t.retention > 0 {
+		expiry := now.Add(-t.retention)
+		drop = sort.Search(len(t.entries), func(i int) bool {
+			return !t.entries[i].Time.Before(expiry)
+		})
+	}
+	if t.maxEntries > 0 {
+		drop = max(drop, len(t.entries)-t.maxEntries)
+	}
+	if drop > 0 {
+		// The backing array is reallocated with the live entries only when appending beyond its capacity.
+		t.entries = t.entries[drop:]
+	}
+}
+
+// Entries returns the recorded entries matching the filter.
+func (t *CostTracker) Entries(filter CostFilter) []CostEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var res []CostEntry
+	for _, e := range t.entries {
+		if filter.match(e) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Total returns the sum of the costs matching the filter, in US dollars.
+func (t *CostTracker) Total(filter CostFilter) float64 {
+	total := 0.0
+	for _, e := range t.Entries(filter) {
+		total += e.Cost.Total
+	}
+	return total
+}
+
+// ByFlow returns the sum of the costs matching the filter per flow name.
+// Requests made outside a flow are under the empty name.
+func (t *CostTracker) ByFlow(filter CostFilter) map[string]float64 {
+	return t.groupBy(filter, func(e CostEntry) string { return e.Flow })
+}
+
+// ByTenant returns the sum of the costs matching the filter per tenant.
+// Requests made without tenant are under the empty name.
+func (t *CostTracker) ByTenant(filter CostFilter) map[string]float64 {
+	return t.groupBy(filter, func(e CostEntry) string { return e.Tenant })
+}
+
+// ByModel returns the sum of the costs matching the filter per model.
+func (t *CostTracker) ByModel(filter CostFilter) map[string]float64 {
+	return t.groupBy(filter, func(e CostEntry) string { return e.Cost.Model })
+}
+
+// ByWindow returns the sum of the costs matching the filter per time window of the given duration.
+// The windows are identified by their start time, see time.Time.Truncate.
+func (t *CostTracker) ByWindow(filter CostFilter, window time.Duration) map[time.Time]float64 {
+	res := make(map[time.Time]float64)
+	for _, e := range t.Entries(filter) {
+		res[e.Time.Truncate(window)] += e.Cost.Total
+	}
+	return res
+}
+
+func (t *CostTracker) groupBy(filter CostFilter, key func(CostEntry) string) map[string]float64 {
+	res := make(map[string]float64)
+	for _, e := range t.Entries(filter) {
+		res[key(e)] += e.Cost.Total
+	}
+	return res
+}
@@ -2,18 +2,28 @@ package mistral
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"strings"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core/api"
 	"github.com/thomas-marquis/genkit-mistral/internal"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
 	"github.com/thomas-marquis/mistral-client/mistral"
 )
 
 const (
 	defaultVectorSize = 1024
+
+	// EmbeddingMetadataInputTokens is the embedding metadata key holding the number of input tokens.
+	EmbeddingMetadataInputTokens = "inputTokens"
+
+	// EmbeddingMetadataInputCharacters is the embedding metadata key holding the number of input characters.
+	EmbeddingMetadataInputCharacters = "inputCharacters"
 )
 
 var (
@@ -22,57 +32,127 @@ var (
 
 type EmbeddingOptions struct {
 	VectorSize int `json:"vectorSize,omitempty"`
+
+	// NoCache bypasses the cache for the request (see WithCache).
+	NoCache bool `json:"noCache,omitempty"`
 }
 
 func newEmbeddingOptionsFromRaw(r map[string]any) *EmbeddingOptions {
 	return &EmbeddingOptions{
 		VectorSize: internal.GetOr[int](r, "vectorSize", defaultVectorSize),
+		NoCache:    internal.GetOr[bool](r, ConfigNoCache, false),
+	}
+}
+
+// embedderConfig holds the plugin settings applied to an embedder.
+type embedderConfig struct {
+	prices PriceTable
+	costs  *CostTracker
+	budget *budgetGuard
+	hooks  hooks
+	cache  *responseCache
+	logger *slog.Logger
+}
+
+// embeddingTexts returns the texts of the documents to embed.
+// Documents with non-text parts are embedded as an empty text, which is logged.
+func embeddingTexts(ctx context.Context, docs []*ai.Document, logger *slog.Logger) []string {
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		text, err := mapping.StringFromParts(doc.Content)
+		if err != nil {
+			logger.WarnContext(ctx, "Non-text parts in the embedding input, embedded as an empty text",
+				slog.Int("document", i), slog.Any("error", err))
+		}
+		texts[i] = text
 	}
+	return texts
 }
 
-func defineEmbedder(client mistral.Client, modelName string) ai.Embedder {
+func defineEmbedder(namespace string, client mistral.Client, modelName string, ec embedderConfig) ai.Embedder {
 	return ai.NewEmbedder(
-		api.NewName(providerID, modelName),
+		api.NewName(namespace, modelName),
 		&ai.EmbedderOptions{},
 		func(ctx context.Context, mr *ai.EmbedRequest) (*ai.EmbedResponse, error) {
 			if len(mr.Input) == 0 {
 				return nil, fmt.Errorf("no messages provided in the model request")
 			}
 
-			texts := make([]string, len(mr.Input))
-			for i, input := range mr.Input {
-				texts[i] = StringFromParts(input.Content)
-			}
+			texts := embeddingTexts(ctx, mr.Input, ec.logger)
 
-			req := mistral.NewEmbeddingRequest(modelName, texts)
-			embResp, err := client.Embeddings(ctx, req)
+			inputTokens := 0
+			for _, text := range texts {
+				inputTokens += tokens.Text(text)
+			}
+			settle, err := ec.budget.reserveRequest(ctx, modelName, ec.prices, inputTokens, 0)
 			if err != nil {
-				return nil, fmt.Errorf("failed to get embedding: %w", err)
+				return nil, err
 			}
 
-			vectors := embResp.Embeddings()
-			embeds := make([]*ai.Embedding, len(vectors))
-			for i, vector := range vectors {
-				embeds[i] = &ai.Embedding{
-					Embedding: vector,
+			bypass := cacheBypassed(ctx, nil)
+			if opts, err := getEmbeddingOptionsFromRequest(mr); err == nil && opts.NoCache {
+				bypass = true
+			}
+
+			call := &Call{
+				Operation:        OperationEmbeddings,
+				EmbedRequest:     mr,
+				EmbeddingRequest: mistral.NewEmbeddingRequest(modelName, texts),
+			}
+			var hits []bool
+			send := func(ctx context.Context, call *Call) error {
+				var err error
+				call.EmbeddingResponse, hits, err = ec.cache.embeddings(ctx, call.EmbeddingRequest, bypass,
+					func(req *mistral.EmbeddingRequest) (*mistral.EmbeddingResponse, error) {
+						return client.Embeddings(ctx, req)
+					})
+				if err != nil {
+					return fmt.Errorf("failed to get embedding: %w", err)
 				}
+				return nil
+			}
+			mapResponse := func(call *Call) error {
+				vectors := call.EmbeddingResponse.Embeddings()
+				if len(vectors) == 0 {
+					return ErrNoEmbeddings
+				}
+				embeds := make([]*ai.Embedding, len(vectors))
+				for i, vector := range vectors {
+					embeds[i] = &ai.Embedding{
+						Embedding: vector,
+					}
+					if i < len(hits) && hits[i] {
+						embeds[i].Metadata = map[string]any{EmbeddingMetadataCacheHit: true}
+					}
+				}
+				call.EmbedResponse = &ai.EmbedResponse{Embeddings: embeds}
+				return nil
+			}
+			if err := ec.hooks.intercept(ctx, call, send, mapResponse); err != nil {
+				// A response without embeddings may still be billed, so its estimated cost is kept.
+				settle(0, !errors.Is(err, ErrNoEmbeddings))
+				return nil, err
 			}
 
-			if len(embeds) == 0 {
-				return nil, ErrNoEmbeddings
+			embResp, embeds := call.EmbeddingResponse, call.EmbedResponse.Embeddings
+			if price, ok := ec.prices.Lookup(modelName); ok {
+				total := price.Cost(modelName, embResp.Usage.PromptTokens, 0)
+				setEmbeddingCosts(embeds, texts, hits, total, price)
+				if ec.costs != nil {
+					ec.costs.Record(ctx, total)
+				}
+				settle(total.Total, true)
 			}
 
-			return &ai.EmbedResponse{
-				Embeddings: embeds,
-			}, nil
+			return call.EmbedResponse, nil
 		},
 	)
 }
 
-func defineFakeEmbedder() ai.Embedder {
+func defineFakeEmbedder(namespace string, rng *fakeRand, logger *slog.Logger) ai.Embedder {
 	modelName := "fake-embed"
 	return ai.NewEmbedder(
-		api.NewName(providerID, modelName),
+		api.NewName(namespace, modelName),
 		&ai.EmbedderOptions{
 			Label:      strings.ToTitle(modelName),
 			Dimensions: defaultVectorSize,
@@ -87,20 +167,22 @@ func defineFakeEmbedder() ai.Embedder {
 				return nil, err
 			}
 
-			texts := make([]string, len(mr.Input))
-			for i, input := range mr.Input {
-				texts[i] = StringFromParts(input.Content)
-			}
+			texts := embeddingTexts(ctx, mr.Input, logger)
 
 			vecSize := cfg.VectorSize
 			if vecSize == 0 {
 				vecSize = defaultVectorSize
 			}
 
+			r := rng.forRequest(0)
 			embeds := make([]*ai.Embedding, len(texts))
-			for i := range texts {
+			for i, text := range texts {
 				embeds[i] = &ai.Embedding{
-					Embedding: createFakeVector(vecSize),
+					Embedding: createFakeVector(r, vecSize),
+					Metadata: map[string]any{
+						EmbeddingMetadataInputTokens:     tokens.Text(text),
+						EmbeddingMetadataInputCharacters: len(text),
+					},
 				}
 			}
 
@@ -111,10 +193,10 @@ func defineFakeEmbedder() ai.Embedder {
 	)
 }
 
-func createFakeVector(size int) []float32 {
+func createFakeVector(rng *rand.Rand, size int) []float32 {
 	embedding := make([]float32, size)
 	for i := range embedding {
-		embedding[i] = rand.Float32()
+		embedding[i] = rng.Float32()
 	}
 	return embedding
 }
@@ -132,3 +214,33 @@ func getEmbeddingOptionsFromRequest(mr *ai.EmbedRequest) (*EmbeddingOptions, err
 	return nil, fmt.Errorf(
 		"invalid embedding request options type: expected EmbeddingOptions, got %T", mr.Options)
 }
+
+// setEmbeddingCosts splits the cost of the request between the embeddings, according to their estimated tokens.
+// The embeddings coming from the cache cost nothing.
+func setEmbeddingCosts(embeds []*ai.Embedding, texts []string, hits []bool, total Cost, price Price) {
+	estimated := make([]int, len(embeds))
+	sum, billed := 0, 0
+	for i := range embeds {
+		if i < len(hits) && hits[i] {
+			continue
+		}
+		if i < len(texts) {
+			estimated[i] = tokens.Text(texts[i])
+		}
+		sum += estimated[i]
+		billed++
+	}
+
+	for i, emb := range embeds {
+		inputTokens := 0
+		if sum > 0 {
+			inputTokens = total.InputTokens * estimated[i] / sum
+		} else if billed > 0 && (i >= len(hits) || !hits[i]) {
+			inputTokens = total.InputTokens / billed
+		}
+		if emb.Metadata == nil {
+			emb.Metadata = make(map[string]any)
+		}
+		emb.Metadata[EmbeddingMetadataCost] = price.Cost(total.Model, inputTokens, 0)
+	}
+}
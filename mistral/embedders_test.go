@@ -1,13 +1,16 @@
 package mistral_test
 
 import (
+	"bytes"
 	"context"
+	"log/slog"
 	"reflect"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/thomas-marquis/genkit-mistral/mistral"
 	"github.com/thomas-marquis/genkit-mistral/mocks"
 	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
@@ -181,5 +184,51 @@ func TestEmbed(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Len(t, res.Embeddings, 1)
 		assert.Len(t, res.Embeddings[0].Embedding, 1024)
+		assert.Equal(t, 4, res.Embeddings[0].Metadata[mistral.EmbeddingMetadataInputTokens])
+		assert.Equal(t, len(inputText), res.Embeddings[0].Metadata[mistral.EmbeddingMetadataInputCharacters])
+	})
+
+	t.Run("should log the non-text inputs with the plugin logger", func(t *testing.T) {
+		// Given
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		p := mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(), mistral.WithLogger(logger))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("Hello, World!", nil),
+				&ai.Document{Content: []*ai.Part{ai.NewMediaPart("image/png", "data:image/png;base64,AAAA")}}),
+			ai.WithEmbedderName("mistral/fake-embed"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Contains(t, buf.String(),
+			`level=WARN msg="Non-text parts in the embedding input, embedded as an empty text" document=1`)
+	})
+
+	t.Run("should generate the same fake vectors with the same seed", func(t *testing.T) {
+		// Given
+		embed := func(seed int) []float32 {
+			ctx := context.Background()
+			g := genkit.Init(ctx, genkit.WithPlugins(
+				mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(), mistral.WithFakeSeed(seed))))
+			res, err := genkit.Embed(ctx, g,
+				ai.WithDocs(ai.DocumentFromText("Hello, World!", nil)),
+				ai.WithEmbedderName("mistral/fake-embed"))
+			require.NoError(t, err)
+			return res.Embeddings[0].Embedding
+		}
+
+		// When
+		first := embed(7)
+		second := embed(7)
+		other := embed(8)
+
+		// Then
+		assert.Equal(t, first, second)
+		assert.NotEqual(t, first, other)
 	})
 }
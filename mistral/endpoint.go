@@ -0,0 +1,239 @@
+package mistral
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+// EndpointProfile describes a Mistral-compatible endpoint other than La Plateforme,
+// e.g. a self-hosted vLLM deployment or a cloud marketplace endpoint.
+// The models keep their Genkit names, e.g. mistral/mistral-small-latest, whatever their names on the endpoint.
+type EndpointProfile struct {
+	// BaseURL is the URL of the endpoint, without the /v1 path, e.g. "http://localhost:8000".
+	// Defaults to La Plateforme.
+	BaseURL string
+
+	// Auth is the way the API key is sent. Defaults to AuthBearer.
+	Auth AuthScheme
+
+	// Transport is the HTTP transport of the client, http.DefaultTransport by default.
+	// Set it here rather than with WithClientOptions, which would replace the transport of the plugin
+	// applying the auth scheme and sending the complete JSON schemas.
+	Transport http.RoundTripper
+
+	// ModelNames maps the model names used with Genkit and with the other options to the names of the endpoint,
+	// e.g. "mistral-small-latest" to "mistralai/Mistral-Small-3.1-24B-Instruct-2503".
+	// Unmapped models have the same name on both sides.
+	ModelNames map[string]string
+
+	// Models is the static list of the models served by the endpoint, by Genkit name,
+	// for the endpoints without the /v1/models route. When set, the route isn't called.
+	Models []mistral.BaseModelCard
+
+	// Capabilities overrides the capabilities of the models, by Genkit name.
+	Capabilities map[string]mistral.ModelCapabilities
+
+	// DefaultCapabilities are the capabilities of the models listed without any, as most non-Mistral servers do.
+	// Embedding models are recognized by their name anyway.
+	DefaultCapabilities mistral.ModelCapabilities
+}
+
+// AuthScheme sets the credentials of a request sent to the endpoint.
+type AuthScheme func(req *http.Request, apiKey string)
+
+// AuthBearer sends the API key in the Authorization header, as a bearer token. This is the La Plateforme scheme.
+func AuthBearer() AuthScheme {
+	return func(req *http.Request, apiKey string) {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
+// AuthHeader sends the raw API key in the given header, e.g. "api-key".
+func AuthHeader(name string) AuthScheme {
+	return func(req *http.Request, apiKey string) {
+		req.Header.Set(name, apiKey)
+	}
+}
+
+// AuthNone sends no credentials, e.g. for a local deployment.
+func AuthNone() AuthScheme {
+	return func(*http.Request, string) {}
+}
+
+// clientOptions returns the options of a client targeting the endpoint, its transport excepted.
+func (e EndpointProfile) clientOptions() []mistral.Option {
+	if e.BaseURL == "" {
+		return nil
+	}
+	return []mistral.Option{mistral.WithBaseApiUrl(e.BaseURL)}
+}
+
+// transport returns the HTTP transport of the endpoint, applying its auth scheme. It may be nil.
+func (e EndpointProfile) transport(apiKey string) http.RoundTripper {
+	if e.Auth == nil {
+		return e.Transport
+	}
+	return &authTransport{
+		base:   e.Transport,
+		apiKey: apiKey,
+		auth:   e.Auth,
+	}
+}
+
+// authTransport replaces the bearer token set by the client with the credentials of the auth scheme.
+type authTransport struct {
+	base   http.RoundTripper
+	apiKey string
+	auth   AuthScheme
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// A round tripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	t.auth(req, t.apiKey)
+	return base.RoundTrip(req)
+}
+
+// endpointClient translates the model names between Genkit and the endpoint, and lists the models of the profile.
+type endpointClient struct {
+	mistral.Client
+
+	profile    EndpointProfile
+	genkitName map[string]string
+}
+
+var _ mistral.Client = &endpointClient{}
+
+func newEndpointClient(c mistral.Client, profile EndpointProfile) *endpointClient {
+	genkitName := make(map[string]string, len(profile.ModelNames))
+	for name, endpointName := range profile.ModelNames {
+		genkitName[endpointName] = name
+	}
+	return &endpointClient{
+		Client:     c,
+		profile:    profile,
+		genkitName: genkitName,
+	}
+}
+
+func (c *endpointClient) endpointModel(name string) string {
+	if endpointName, ok := c.profile.ModelNames[name]; ok {
+		return endpointName
+	}
+	return name
+}
+
+func (c *endpointClient) genkitModel(name string) string {
+	if genkitName, ok := c.genkitName[name]; ok {
+		return genkitName
+	}
+	return name
+}
+
+func (c *endpointClient) ChatCompletion(ctx context.Context, req *mistral.ChatCompletionRequest) (*mistral.ChatCompletionResponse, error) {
+	sent := *req
+	sent.Model = c.endpointModel(req.Model)
+	resp, err := c.Client.ChatCompletion(ctx, &sent)
+	if err != nil {
+		return nil, err
+	}
+	resp.Model = c.genkitModel(resp.Model)
+	return resp, nil
+}
+
+func (c *endpointClient) ChatCompletionStream(ctx context.Context, req *mistral.ChatCompletionRequest) (<-chan *mistral.CompletionChunk, error) {
+	sent := *req
+	sent.Model = c.endpointModel(req.Model)
+	chunks, err := c.Client.ChatCompletionStream(ctx, &sent)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *mistral.CompletionChunk)
+	go func() {
+		defer close(out)
+		for chunk := range chunks {
+			chunk.Model = c.genkitModel(chunk.Model)
+			out <- chunk
+		}
+	}()
+	return out, nil
+}
+
+func (c *endpointClient) Embeddings(ctx context.Context, req *mistral.EmbeddingRequest) (*mistral.EmbeddingResponse, error) {
+	sent := *req
+	sent.Model = c.endpointModel(req.Model)
+	resp, err := c.Client.Embeddings(ctx, &sent)
+	if err != nil {
+		return nil, err
+	}
+	resp.Model = c.genkitModel(resp.Model)
+	return resp, nil
+}
+
+func (c *endpointClient) ListModels(ctx context.Context) ([]*mistral.BaseModelCard, error) {
+	if c.profile.Models != nil {
+		cards := make([]*mistral.BaseModelCard, len(c.profile.Models))
+		for i, card := range c.profile.Models {
+			cards[i] = c.withCapabilities(card)
+		}
+		return cards, nil
+	}
+
+	listed, err := c.Client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cards := make([]*mistral.BaseModelCard, len(listed))
+	for i, card := range listed {
+		renamed := *card
+		renamed.Id = c.genkitModel(card.Id)
+		cards[i] = c.withCapabilities(renamed)
+	}
+	return cards, nil
+}
+
+func (c *endpointClient) SearchModels(ctx context.Context, capabilities *mistral.ModelCapabilities) ([]*mistral.BaseModelCard, error) {
+	cards, err := c.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var found []*mistral.BaseModelCard
+	for _, card := range cards {
+		if card.Match(capabilities) {
+			found = append(found, card)
+		}
+	}
+	return found, nil
+}
+
+func (c *endpointClient) GetModel(ctx context.Context, modelId string) (*mistral.BaseModelCard, error) {
+	cards, err := c.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range cards {
+		if card.Id == modelId {
+			return card, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", mistral.ErrModelNotFound, modelId)
+}
+
+// withCapabilities returns the card with the capabilities of the profile.
+func (c *endpointClient) withCapabilities(card mistral.BaseModelCard) *mistral.BaseModelCard {
+	if capabilities, ok := c.profile.Capabilities[card.Id]; ok {
+		card.Capabilities = capabilities
+	} else if card.HasNoCapabilities() && !card.IsEmbedding() {
+		card.Capabilities = c.profile.DefaultCapabilities
+	}
+	return &card
+}
@@ -0,0 +1,174 @@
+package mistral_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+)
+
+// standInServer mimics a Mistral-compatible endpoint: it answers the chat completions with the requested model,
+// and lists its models only when listModels is set.
+type standInServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	headers []http.Header
+	models  []string
+}
+
+func newStandInServer(t *testing.T, listModels []string) *standInServer {
+	s := &standInServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+		s.record(r, "")
+		if listModels == nil {
+			http.NotFound(w, r)
+			return
+		}
+		data := make([]map[string]any, len(listModels))
+		for i, id := range listModels {
+			data[i] = map[string]any{"id": id, "object": "model", "owned_by": "vllm"}
+		}
+		writeJSON(t, w, map[string]any{"object": "list", "data": data})
+	})
+	mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		s.record(r, req.Model)
+		writeJSON(t, w, map[string]any{
+			"id":     "cmpl-1",
+			"object": "chat.completion",
+			"model":  req.Model,
+			"choices": []map[string]any{{
+				"index":         0,
+				"message":       map[string]any{"role": "assistant", "content": "Hello from " + req.Model},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]any{"prompt_tokens": 3, "completion_tokens": 4, "total_tokens": 7},
+		})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standInServer) record(r *http.Request, model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.headers = append(s.headers, r.Header.Clone())
+	if model != "" {
+		s.models = append(s.models, model)
+	}
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+func TestGenerateWithEndpoint(t *testing.T) {
+	t.Run("should use the static models and the auth scheme of the profile", func(t *testing.T) {
+		// Given
+		server := newStandInServer(t, nil)
+		p := mistral.NewPlugin("secret",
+			mistral.WithEndpoint(mistral.EndpointProfile{
+				BaseURL:    server.URL,
+				Auth:       mistral.AuthHeader("api-key"),
+				ModelNames: map[string]string{"mistral-large-latest": "Mistral-Large-2411"},
+				Models: []mistralclient.BaseModelCard{
+					{Id: "mistral-large-latest"},
+				},
+				Capabilities: map[string]mistralclient.ModelCapabilities{
+					"mistral-large-latest": {CompletionChat: true, FunctionCalling: true},
+				},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/mistral-large-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from Mistral-Large-2411", res.Text())
+		assert.Equal(t, []string{"Mistral-Large-2411"}, server.models)
+		require.Len(t, server.headers, 1)
+		assert.Equal(t, "secret", server.headers[0].Get("api-key"))
+		assert.Empty(t, server.headers[0].Get("Authorization"))
+	})
+
+	t.Run("should rename the listed models and give them the default capabilities", func(t *testing.T) {
+		// Given
+		server := newStandInServer(t, []string{"mistralai/Mistral-Small-3.1-24B-Instruct-2503", "other-model"})
+		p := mistral.NewPlugin("",
+			mistral.WithNamespace("self-hosted"),
+			mistral.WithEndpoint(mistral.EndpointProfile{
+				BaseURL: server.URL,
+				Auth:    mistral.AuthNone(),
+				ModelNames: map[string]string{
+					"mistral-small-latest": "mistralai/Mistral-Small-3.1-24B-Instruct-2503",
+				},
+				DefaultCapabilities: mistralclient.ModelCapabilities{CompletionChat: true},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(
+				ai.NewSystemTextMessage("Be nice"),
+				ai.NewUserTextMessage("Hello")),
+			ai.WithModelName("self-hosted/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from mistralai/Mistral-Small-3.1-24B-Instruct-2503", res.Text())
+		assert.Equal(t, []string{"mistralai/Mistral-Small-3.1-24B-Instruct-2503"}, server.models)
+		for _, h := range server.headers {
+			assert.Empty(t, h.Get("Authorization"))
+		}
+		assert.NotNil(t, genkit.LookupModel(g, "self-hosted/other-model"))
+		assert.Nil(t, genkit.LookupModel(g, "self-hosted/mistralai/Mistral-Small-3.1-24B-Instruct-2503"))
+	})
+
+	t.Run("should send the API key as a bearer token by default", func(t *testing.T) {
+		// Given
+		server := newStandInServer(t, []string{"mistral-small-latest"})
+		p := mistral.NewPlugin("secret",
+			mistral.WithEndpoint(mistral.EndpointProfile{
+				BaseURL:             server.URL,
+				DefaultCapabilities: mistralclient.ModelCapabilities{CompletionChat: true},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		require.Len(t, server.headers, 2)
+		for _, h := range server.headers {
+			assert.Equal(t, "Bearer secret", h.Get("Authorization"))
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package mistral
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core/api"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+// ResponseMetadataModel is the response message metadata key holding the model which actually answered
+// a request to a virtual model or an alias.
+const ResponseMetadataModel = "model"
+
+// FallbackPolicy defines a virtual model: the requests are sent to the first model able to handle them,
+// and to the next ones when it fails.
+type FallbackPolicy struct {
+	// Models are the Mistral models tried in order, e.g. "mistral-large-latest".
+	// Models not supporting the tools, media, system messages or tool choice of a request are skipped.
+	Models []string
+
+	// ShouldFallback tells whether an error of a model triggers the next one. Defaults to IsTransientError.
+	ShouldFallback func(err error) bool
+}
+
+// IsTransientError reports whether the error may not happen with another model or later:
+// rate limits, server errors and timeouts.
+func IsTransientError(err error) bool {
+	var apiErr mistral.ApiError
+	if errors.As(err, &apiErr) {
+		code := apiErr.Code()
+		return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+type virtualModel struct {
+	name   string
+	policy FallbackPolicy
+}
+
+// defineVirtualModel defines a model delegating the requests to the candidates, in order, with its default config.
+// It supports what at least one candidate supports, the constrained output excepted:
+// the least capable candidate is assumed, so that the instructions Genkit adds to the prompt are never missing.
+func defineVirtualModel(
+	namespace, name string, policy FallbackPolicy, candidates []definedModel, defaults *mistral.CompletionConfig, logger *slog.Logger,
+) definedModel {
+	shouldFallback := policy.ShouldFallback
+	if shouldFallback == nil {
+		shouldFallback = IsTransientError
+	}
+
+	supports := &ai.ModelSupports{Constrained: ai.ConstrainedSupportAll}
+	for _, c := range candidates {
+		s := c.info.Supports
+		supports.Media = supports.Media || s.Media
+		supports.Multiturn = supports.Multiturn || s.Multiturn
+		supports.SystemRole = supports.SystemRole || s.SystemRole
+		supports.Tools = supports.Tools || s.Tools
+		supports.ToolChoice = supports.ToolChoice || s.ToolChoice
+		supports.Constrained = leastConstrained(supports.Constrained, s.Constrained)
+	}
+
+	info := &ai.ModelInfo{Label: name, Supports: supports}
+	model := ai.NewModel(
+		api.NewName(namespace, name),
+		&ai.ModelOptions{
+			Label:    info.Label,
+			Supports: info.Supports,
+		},
+		func(ctx context.Context, mr *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			mr, err := withConfigDefaults(mr, defaults)
+			if err != nil {
+				return nil, err
+			}
+
+			var errs []error
+			for i, c := range candidates {
+				if !supportsRequest(c.info.Supports, mr) {
+					continue
+				}
+
+				streamed := false
+				var streamCB ai.ModelStreamCallback
+				if cb != nil {
+					streamCB = func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+						streamed = true
+						return cb(ctx, chunk)
+					}
+				}
+
+				resp, err := c.model.Generate(ctx, mr, streamCB)
+				if err == nil {
+					if resp.Message != nil {
+						if resp.Message.Metadata == nil {
+							resp.Message.Metadata = make(map[string]any)
+						}
+						resp.Message.Metadata[ResponseMetadataModel] = c.name
+					}
+					return resp, nil
+				}
+
+				errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+				// A partially streamed response can't be taken back.
+				if streamed || ctx.Err() != nil || !shouldFallback(err) {
+					break
+				}
+				if i < len(candidates)-1 {
+					logger.WarnContext(ctx, "Model failed, falling back",
+						slog.String("virtualModel", name), slog.String("model", c.name), slog.Any("error", err))
+				}
+			}
+
+			if len(errs) == 0 {
+				return nil, errors.Join(ErrInvalidModelInput,
+					fmt.Errorf("no model of %s supports the request", name))
+			}
+			return nil, fmt.Errorf("%s failed: %w", name, errors.Join(errs...))
+		},
+	)
+	return definedModel{name: name, model: model, info: info}
+}
+
+// supportsRequest checks the capabilities required by the request against the model ones.
+func supportsRequest(s *ai.ModelSupports, mr *ai.ModelRequest) bool {
+	if len(mr.Tools) > 0 && !s.Tools {
+		return false
+	}
+	if mr.ToolChoice != "" && mr.ToolChoice != ai.ToolChoiceAuto && !s.ToolChoice {
+		return false
+	}
+	for _, msg := range mr.Messages {
+		if msg.Role == ai.RoleSystem && !s.SystemRole {
+			return false
+		}
+		if !s.Media {
+			for _, part := range msg.Content {
+				if part.IsMedia() {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func leastConstrained(a, b ai.ConstrainedSupport) ai.ConstrainedSupport {
+	rank := map[ai.ConstrainedSupport]int{
+		ai.ConstrainedSupportNone:    0,
+		"":                           0,
+		ai.ConstrainedSupportNoTools: 1,
+		ai.ConstrainedSupportAll:     2,
+	}
+	if rank[b] < rank[a] {
+		return b
+	}
+	return a
+}
+
+// modelID returns the Mistral identifier of a model name, with or without the plugin namespace.
+func modelID(namespace, name string) string {
+	return strings.TrimPrefix(name, namespace+"/")
+}
@@ -0,0 +1,131 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGenerateWithVirtualModel(t *testing.T) {
+	setup := func(t *testing.T, errs map[string]error, policy mistral.FallbackPolicy) (*genkit.Genkit, *[]string) {
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		mockClient.EXPECT().
+			ListModels(gomock.Any()).
+			Return([]*mistralclient.BaseModelCard{
+				{Id: "mistral-large-latest", Capabilities: mistralclient.ModelCapabilities{CompletionChat: true, FunctionCalling: true}},
+				{Id: "mistral-medium-latest", Capabilities: mistralclient.ModelCapabilities{CompletionChat: true}},
+				{Id: "mistral-small-latest", Capabilities: mistralclient.ModelCapabilities{CompletionChat: true, FunctionCalling: true}},
+			}, nil)
+
+		var called []string
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *mistralclient.ChatCompletionRequest) (*mistralclient.ChatCompletionResponse, error) {
+				called = append(called, req.Model)
+				if err := errs[req.Model]; err != nil {
+					return nil, err
+				}
+				return &mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{Message: mistralclient.NewAssistantMessageFromString("Hello from " + req.Model)},
+					},
+				}, nil
+			}).
+			AnyTimes()
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithVirtualModel("resilient-chat", policy))
+		return genkit.Init(context.Background(), genkit.WithPlugins(p)), &called
+	}
+
+	chain := []string{"mistral-large-latest", "mistral-medium-latest", "mistral-small-latest"}
+
+	t.Run("should fall back to the next model on transient errors", func(t *testing.T) {
+		// Given
+		g, called := setup(t, map[string]error{
+			"mistral-large-latest":  mistralclient.NewApiError(503, nil),
+			"mistral-medium-latest": mistralclient.NewApiError(429, nil),
+		}, mistral.FallbackPolicy{Models: chain})
+
+		// When
+		res, err := genkit.Generate(context.Background(), g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/resilient-chat"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hello from mistral-small-latest", res.Text())
+		assert.Equal(t, "mistral-small-latest", res.Message.Metadata[mistral.ResponseMetadataModel])
+		assert.Equal(t, chain, *called)
+	})
+
+	t.Run("should not fall back on other errors", func(t *testing.T) {
+		// Given
+		g, called := setup(t, map[string]error{
+			"mistral-large-latest": mistralclient.NewApiError(400, nil),
+		}, mistral.FallbackPolicy{Models: chain})
+
+		// When
+		_, err := genkit.Generate(context.Background(), g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/resilient-chat"))
+
+		// Then
+		assert.Error(t, err)
+		assert.Equal(t, []string{"mistral-large-latest"}, *called)
+	})
+
+	t.Run("should fall back on the errors chosen by the policy", func(t *testing.T) {
+		// Given
+		g, called := setup(t, map[string]error{
+			"mistral-large-latest": mistralclient.NewApiError(400, nil),
+		}, mistral.FallbackPolicy{
+			Models:         chain,
+			ShouldFallback: func(error) bool { return true },
+		})
+
+		// When
+		res, err := genkit.Generate(context.Background(), g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/resilient-chat"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "mistral-medium-latest", res.Message.Metadata[mistral.ResponseMetadataModel])
+		assert.Equal(t, []string{"mistral-large-latest", "mistral-medium-latest"}, *called)
+	})
+
+	t.Run("should skip the models not supporting the request", func(t *testing.T) {
+		// Given
+		g, called := setup(t, map[string]error{
+			"mistral-large-latest": mistralclient.NewApiError(503, nil),
+		}, mistral.FallbackPolicy{Models: chain})
+		tool := genkit.DefineTool(g, "weather", "Gives the weather",
+			func(ctx *ai.ToolContext, input struct{}) (string, error) {
+				return "sunny", nil
+			})
+
+		// When
+		res, err := genkit.Generate(context.Background(), g,
+			ai.WithPrompt("What's the weather?"),
+			ai.WithTools(tool),
+			ai.WithReturnToolRequests(true),
+			ai.WithModelName("mistral/resilient-chat"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "mistral-small-latest", res.Message.Metadata[mistral.ResponseMetadataModel])
+		assert.Equal(t, []string{"mistral-large-latest", "mistral-small-latest"}, *called)
+	})
+}
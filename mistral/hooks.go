@@ -0,0 +1,142 @@
+package mistral
+
+import (
+	"context"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/redact"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+// Operation is the kind of call made to Mistral.
+type Operation string
+
+const (
+	OperationChat       Operation = "chat"
+	OperationChatStream Operation = "chat_stream"
+	OperationEmbeddings Operation = "embeddings"
+)
+
+// Call holds the requests and responses of a call to Mistral, as seen by the hooks.
+// Only the fields matching the operation are set.
+type Call struct {
+	Operation Operation
+
+	// ModelRequest is the Genkit request of a chat completion.
+	ModelRequest *ai.ModelRequest
+	// ChatRequest is the request sent to Mistral, built from ModelRequest.
+	ChatRequest *mistral.ChatCompletionRequest
+	// ChatResponse is the raw response of Mistral. For streams, it is assembled from the chunks.
+	ChatResponse *mistral.ChatCompletionResponse
+	// ModelResponse is the Genkit response, mapped from ChatResponse.
+	ModelResponse *ai.ModelResponse
+
+	// EmbedRequest is the Genkit request of an embedding.
+	EmbedRequest *ai.EmbedRequest
+	// EmbeddingRequest is the request sent to Mistral, built from EmbedRequest.
+	EmbeddingRequest *mistral.EmbeddingRequest
+	// EmbeddingResponse is the raw response of Mistral.
+	EmbeddingResponse *mistral.EmbeddingResponse
+	// EmbedResponse is the Genkit response, mapped from EmbeddingResponse.
+	EmbedResponse *ai.EmbedResponse
+
+	// redaction holds the values redacted from the request, never exposed to the hooks.
+	redaction *redact.Session
+}
+
+// responded reports whether the raw response of the call is known.
+func (c *Call) responded() bool {
+	return c.ChatResponse != nil || c.EmbeddingResponse != nil
+}
+
+// Hook intercepts the chat completion, streaming and embedding calls made by the plugin models and embedders.
+//
+// BeforeRequest is called in the registration order, once the Mistral request is built. It may modify the request.
+// Setting the raw response (ChatResponse or EmbeddingResponse) short-circuits the call: Mistral isn't called,
+// the BeforeRequest of the next hooks is skipped and the response is mapped as if Mistral returned it.
+//
+// AfterResponse and OnError are called in the reverse order, and only for the hooks whose BeforeRequest succeeded.
+// AfterResponse receives both the raw and the mapped responses, and may modify the mapped one.
+// OnError receives the error of Mistral, of the mapping or of a hook, and returns the error passed to the next hook
+// and finally to the caller. Returning nil keeps the received error: an error can be replaced, not cleared.
+// A hook whose own BeforeRequest or AfterResponse fails doesn't receive the error.
+type Hook interface {
+	BeforeRequest(ctx context.Context, call *Call) error
+	AfterResponse(ctx context.Context, call *Call) error
+	OnError(ctx context.Context, call *Call, err error) error
+}
+
+// HookFuncs is a Hook made of optional functions.
+type HookFuncs struct {
+	Before func(ctx context.Context, call *Call) error
+	After  func(ctx context.Context, call *Call) error
+	OnErr  func(ctx context.Context, call *Call, err error) error
+}
+
+var _ Hook = HookFuncs{}
+
+func (h HookFuncs) BeforeRequest(ctx context.Context, call *Call) error {
+	if h.Before == nil {
+		return nil
+	}
+	return h.Before(ctx, call)
+}
+
+func (h HookFuncs) AfterResponse(ctx context.Context, call *Call) error {
+	if h.After == nil {
+		return nil
+	}
+	return h.After(ctx, call)
+}
+
+func (h HookFuncs) OnError(ctx context.Context, call *Call, err error) error {
+	if h.OnErr == nil {
+		return err
+	}
+	return h.OnErr(ctx, call, err)
+}
+
+type hooks []Hook
+
+// intercept runs the call through the hooks. send calls Mistral and sets the raw response,
+// mapResponse sets the Genkit response from the raw one.
+func (h hooks) intercept(
+	ctx context.Context,
+	call *Call,
+	send func(context.Context, *Call) error,
+	mapResponse func(*Call) error,
+) error {
+	var err error
+	ran := 0
+	for _, hook := range h {
+		if err = hook.BeforeRequest(ctx, call); err != nil {
+			break
+		}
+		ran++
+		if call.responded() {
+			break
+		}
+	}
+
+	if err == nil && !call.responded() {
+		err = send(ctx, call)
+	}
+	if err == nil {
+		err = mapResponse(call)
+	}
+	for i := ran - 1; i >= 0 && err == nil; i-- {
+		if err = h[i].AfterResponse(ctx, call); err != nil {
+			// Like for BeforeRequest, the error goes to the outer hooks only.
+			ran = i
+		}
+	}
+
+	if err != nil {
+		for i := ran - 1; i >= 0; i-- {
+			if replaced := h[i].OnError(ctx, call, err); replaced != nil {
+				err = replaced
+			}
+		}
+	}
+	return err
+}
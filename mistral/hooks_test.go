@@ -0,0 +1,283 @@
+package mistral_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+// recordingHook records the hook calls in the shared trace.
+func recordingHook(name string, trace *[]string) mistral.HookFuncs {
+	return mistral.HookFuncs{
+		Before: func(_ context.Context, _ *mistral.Call) error {
+			*trace = append(*trace, name+".before")
+			return nil
+		},
+		After: func(_ context.Context, _ *mistral.Call) error {
+			*trace = append(*trace, name+".after")
+			return nil
+		},
+		OnErr: func(_ context.Context, _ *mistral.Call, err error) error {
+			*trace = append(*trace, name+".error")
+			return err
+		},
+	}
+}
+
+func TestGenerateWithHooks(t *testing.T) {
+	t.Run("should run the hooks in order around the call", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return x.Temperature == 0.2
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Id: "resp-1",
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+				},
+			}, nil)
+
+		var trace []string
+		var seen *mistral.Call
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithHooks(
+				recordingHook("first", &trace),
+				mistral.HookFuncs{
+					Before: func(_ context.Context, call *mistral.Call) error {
+						call.ChatRequest.Temperature = 0.2
+						return nil
+					},
+					After: func(_ context.Context, call *mistral.Call) error {
+						seen = call
+						call.ModelResponse.Message.Content = []*ai.Part{ai.NewTextPart("Hooked!")}
+						return nil
+					},
+				},
+				recordingHook("last", &trace),
+			))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hi"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hooked!", res.Text())
+		assert.Equal(t, []string{"first.before", "last.before", "last.after", "first.after"}, trace)
+		require.NotNil(t, seen)
+		assert.Equal(t, mistral.OperationChat, seen.Operation)
+		assert.Equal(t, "Hi", seen.ModelRequest.Messages[0].Text())
+		assert.Equal(t, "resp-1", seen.ChatResponse.Id)
+	})
+
+	t.Run("should short-circuit the call with a synthetic response", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.Any(), gomock.Any()).
+			Times(0)
+
+		var trace []string
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithHooks(
+				recordingHook("first", &trace),
+				mistral.HookFuncs{
+					Before: func(_ context.Context, call *mistral.Call) error {
+						call.ChatResponse = &mistralclient.ChatCompletionResponse{
+							Choices: []mistralclient.ChatCompletionChoice{
+								{Message: mistralclient.NewAssistantMessageFromString("From the hook")},
+							},
+						}
+						return nil
+					},
+				},
+				recordingHook("skipped", &trace),
+			))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hi"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "From the hook", res.Text())
+		assert.Equal(t, []string{"first.before", "first.after"}, trace)
+	})
+
+	t.Run("should pass the errors through the error hooks in reverse order", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.Any(), gomock.Any()).
+			Return(nil, mistralclient.NewApiError(503, nil))
+
+		errUnavailable := errors.New("mistral is unavailable")
+		var trace []string
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithHooks(
+				recordingHook("first", &trace),
+				mistral.HookFuncs{
+					OnErr: func(_ context.Context, _ *mistral.Call, err error) error {
+						trace = append(trace, "replacing.error")
+						return errors.Join(errUnavailable, err)
+					},
+				},
+				recordingHook("last", &trace),
+			))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hi"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorIs(t, err, errUnavailable)
+		assert.ErrorContains(t, err, "failed to get chat completion")
+		assert.Equal(t, []string{"first.before", "last.before", "last.error", "replacing.error", "first.error"}, trace)
+	})
+
+	t.Run("should intercept streamed completions", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		chunks := make(chan *mistralclient.CompletionChunk, 2)
+		chunks <- &mistralclient.CompletionChunk{
+			Id:      "resp-1",
+			Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString("Hel")}},
+		}
+		chunks <- &mistralclient.CompletionChunk{
+			Id: "resp-1",
+			Choices: []mistralclient.CompletionResponseStreamChoice{{
+				Delta:        mistralclient.NewAssistantMessageFromString("lo!"),
+				FinishReason: mistralclient.FinishReasonStop,
+			}},
+			Usage: &mistralclient.UsageInfo{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+		}
+		close(chunks)
+
+		mockClient.EXPECT().
+			ChatCompletionStream(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return x.Stream
+				}),
+			).
+			Return((<-chan *mistralclient.CompletionChunk)(chunks), nil)
+
+		var seen *mistral.Call
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithHooks(mistral.HookFuncs{
+				After: func(_ context.Context, call *mistral.Call) error {
+					seen = call
+					return nil
+				},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		var streamed []string
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hi"),
+			ai.WithModelName("mistral/mistral-small-latest"),
+			ai.WithStreaming(func(_ context.Context, chunk *ai.ModelResponseChunk) error {
+				streamed = append(streamed, chunk.Text())
+				return nil
+			}))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hello!", res.Text())
+		assert.Equal(t, []string{"Hel", "lo!"}, streamed)
+		assert.Equal(t, 7, res.Usage.TotalTokens)
+		require.NotNil(t, seen)
+		assert.Equal(t, mistral.OperationChatStream, seen.Operation)
+		assert.Equal(t, "resp-1", seen.ChatResponse.Id)
+		assert.Equal(t, mistralclient.FinishReasonStop, seen.ChatResponse.Choices[0].FinishReason)
+	})
+}
+
+func TestEmbedWithHooks(t *testing.T) {
+	t.Run("should short-circuit the embedding call", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithEmbedding(mockClient)
+
+		mockClient.EXPECT().
+			Embeddings(gomock.Any(), gomock.Any()).
+			Times(0)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithHooks(mistral.HookFuncs{
+				Before: func(_ context.Context, call *mistral.Call) error {
+					assert.Equal(t, mistral.OperationEmbeddings, call.Operation)
+					assert.Equal(t, []string{"Hello, World!"}, call.EmbeddingRequest.Input)
+					call.EmbeddingResponse = &mistralclient.EmbeddingResponse{
+						Data: []mistralclient.EmbeddingData{{Embedding: []float32{1, 2, 3}}},
+					}
+					return nil
+				},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("Hello, World!", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, []float32{1, 2, 3}, res.Embeddings[0].Embedding)
+	})
+}
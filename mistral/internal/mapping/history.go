@@ -0,0 +1,186 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+var ErrInvalidHistory = errors.New("invalid message history")
+
+// SystemMessagePolicy defines how system messages found after the beginning of the conversation are handled.
+type SystemMessagePolicy int
+
+const (
+	// SystemMessagesStrict rejects system messages found after the beginning of the conversation.
+	SystemMessagesStrict SystemMessagePolicy = iota
+
+	// SystemMessagesHoist moves every system message to the beginning of the conversation, keeping their order.
+	SystemMessagesHoist
+
+	// SystemMessagesMerge merges every system message into a single one, at the beginning of the conversation.
+	SystemMessagesMerge
+)
+
+// HistoryPolicy defines how the message history is normalized before being checked against Mistral ordering rules.
+type HistoryPolicy struct {
+	// System is the way misplaced system messages are handled.
+	System SystemMessagePolicy
+
+	// MergeConsecutive merges consecutive user messages and consecutive model messages.
+	MergeConsecutive bool
+
+	// DropOrphanToolResponses removes the tool responses not answering a previous tool request.
+	DropOrphanToolResponses bool
+}
+
+// WithHistoryPolicy sets the normalization applied to the message history.
+// By default, the history is only validated.
+func WithHistoryPolicy(policy HistoryPolicy) RequestOption {
+	return func(o *requestOptions) {
+		o.history = policy
+	}
+}
+
+// normalizeHistory applies the policy to the messages and checks the result follows Mistral ordering rules.
+// The original messages are left untouched.
+func normalizeHistory(messages []*ai.Message, policy HistoryPolicy) ([]*ai.Message, error) {
+	switch policy.System {
+	case SystemMessagesHoist:
+		messages = hoistSystemMessages(messages, false)
+	case SystemMessagesMerge:
+		messages = hoistSystemMessages(messages, true)
+	}
+	if policy.DropOrphanToolResponses {
+		messages = dropOrphanToolResponses(messages)
+	}
+	if policy.MergeConsecutive {
+		messages = mergeConsecutiveMessages(messages)
+	}
+
+	if err := validateHistory(messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func hoistSystemMessages(messages []*ai.Message, merge bool) []*ai.Message {
+	var system, others []*ai.Message
+	for _, msg := range messages {
+		if msg.Role == ai.RoleSystem {
+			system = append(system, msg)
+		} else {
+			others = append(others, msg)
+		}
+	}
+
+	if merge && len(system) > 1 {
+		merged := copyMessage(system[0])
+		for _, msg := range system[1:] {
+			merged.Content = append(merged.Content, msg.Content...)
+		}
+		system = []*ai.Message{merged}
+	}
+
+	return append(system, others...)
+}
+
+func dropOrphanToolResponses(messages []*ai.Message) []*ai.Message {
+	calls := newToolCalls(nil)
+	res := make([]*ai.Message, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case ai.RoleModel:
+			for _, part := range msg.Content {
+				if part.IsToolRequest() {
+					calls.call(part.ToolRequest)
+				}
+			}
+		case ai.RoleTool:
+			kept := copyMessage(msg)
+			kept.Content = kept.Content[:0]
+			for _, part := range msg.Content {
+				if part.IsToolResponse() {
+					if _, err := calls.respond(part.ToolResponse); err != nil {
+						continue
+					}
+				}
+				kept.Content = append(kept.Content, part)
+			}
+			if len(kept.Content) == 0 {
+				continue
+			}
+			msg = kept
+		}
+		res = append(res, msg)
+	}
+	return res
+}
+
+func mergeConsecutiveMessages(messages []*ai.Message) []*ai.Message {
+	res := make([]*ai.Message, 0, len(messages))
+	for _, msg := range messages {
+		if len(res) > 0 {
+			last := res[len(res)-1]
+			if last.Role == msg.Role && (msg.Role == ai.RoleUser || msg.Role == ai.RoleModel) &&
+				!isPrefix(last) && !isPrefix(msg) {
+				merged := copyMessage(last)
+				merged.Content = append(merged.Content, msg.Content...)
+				res[len(res)-1] = merged
+				continue
+			}
+		}
+		res = append(res, msg)
+	}
+	return res
+}
+
+// validateHistory checks the messages follow Mistral ordering rules.
+func validateHistory(messages []*ai.Message) error {
+	for i, msg := range messages {
+		var prev *ai.Message
+		if i > 0 {
+			prev = messages[i-1]
+		}
+
+		switch msg.Role {
+		case ai.RoleSystem:
+			if prev != nil && prev.Role != ai.RoleSystem {
+				return fmt.Errorf("%w: message %d: system messages must be at the beginning of the conversation",
+					ErrInvalidHistory, i)
+			}
+		case ai.RoleModel:
+			if prev != nil && prev.Role == ai.RoleModel {
+				return fmt.Errorf("%w: message %d: consecutive model messages", ErrInvalidHistory, i)
+			}
+		case ai.RoleTool:
+			if prev == nil || !(prev.Role == ai.RoleTool || prev.Role == ai.RoleModel && hasToolRequests(prev)) {
+				return fmt.Errorf("%w: message %d: tool messages must follow a model message with tool requests",
+					ErrInvalidHistory, i)
+			}
+		}
+	}
+
+	last := messages[len(messages)-1]
+	if last.Role != ai.RoleUser && last.Role != ai.RoleTool && !(last.Role == ai.RoleModel && isPrefix(last)) {
+		return fmt.Errorf("%w: the last message must be a user or tool message, or a response prefix, got %s",
+			ErrInvalidHistory, last.Role)
+	}
+	return nil
+}
+
+func hasToolRequests(msg *ai.Message) bool {
+	for _, part := range msg.Content {
+		if part.IsToolRequest() {
+			return true
+		}
+	}
+	return false
+}
+
+func copyMessage(msg *ai.Message) *ai.Message {
+	c := *msg
+	c.Content = append([]*ai.Part{}, msg.Content...)
+	return &c
+}
@@ -0,0 +1,168 @@
+package mapping_test
+
+import (
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+func toolRequestMessage(name, ref string) *ai.Message {
+	return ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{Name: name, Ref: ref}))
+}
+
+func toolResponseMessage(name, ref string) *ai.Message {
+	return ai.NewMessage(ai.RoleTool, nil, ai.NewToolResponsePart(&ai.ToolResponse{Name: name, Ref: ref, Output: "ok"}))
+}
+
+func TestMapRequestToMistralHistory(t *testing.T) {
+	t.Run("should reject invalid histories", func(t *testing.T) {
+		for _, tc := range []struct {
+			name     string
+			messages []*ai.Message
+			expected string
+		}{
+			{
+				"with a misplaced system message",
+				[]*ai.Message{ai.NewUserTextMessage("Hello"), ai.NewSystemTextMessage("Be nice")},
+				"message 1: system messages must be at the beginning of the conversation",
+			},
+			{
+				"with consecutive model messages",
+				[]*ai.Message{
+					ai.NewUserTextMessage("Hello"),
+					ai.NewModelTextMessage("Hi"),
+					ai.NewModelTextMessage("How are you?"),
+					ai.NewUserTextMessage("Fine"),
+				},
+				"message 2: consecutive model messages",
+			},
+			{
+				"with a tool message not following a tool request",
+				[]*ai.Message{ai.NewUserTextMessage("Hello"), toolResponseMessage("search", "ref1")},
+				"message 1: tool messages must follow a model message with tool requests",
+			},
+			{
+				"with a final model message",
+				[]*ai.Message{ai.NewUserTextMessage("Hello"), ai.NewModelTextMessage("Hi")},
+				"the last message must be a user or tool message, or a response prefix, got model",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				// When
+				res, _, err := mapping.MapRequestToMistral("mistral-small-latest",
+					&ai.ModelRequest{Messages: tc.messages}, nil)
+
+				// Then
+				assert.Nil(t, res)
+				assert.ErrorIs(t, err, mapping.ErrInvalidHistory)
+				assert.ErrorContains(t, err, tc.expected)
+			})
+		}
+	})
+
+	t.Run("should hoist system messages", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{Messages: []*ai.Message{
+			ai.NewSystemTextMessage("Be nice"),
+			ai.NewUserTextMessage("Hello"),
+			ai.NewSystemTextMessage("Be brief"),
+		}}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil,
+			mapping.WithHistoryPolicy(mapping.HistoryPolicy{System: mapping.SystemMessagesHoist}))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(res.Messages))
+		assert.Equal(t, mistral.RoleSystem, res.Messages[0].Role())
+		assert.Equal(t, "Be nice", res.Messages[0].Content().String())
+		assert.Equal(t, mistral.RoleSystem, res.Messages[1].Role())
+		assert.Equal(t, "Be brief", res.Messages[1].Content().String())
+		assert.Equal(t, mistral.RoleUser, res.Messages[2].Role())
+		assert.Equal(t, 3, len(mr.Messages))
+		assert.Equal(t, ai.RoleSystem, mr.Messages[2].Role)
+	})
+
+	t.Run("should merge system messages", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{Messages: []*ai.Message{
+			ai.NewSystemTextMessage("Be nice"),
+			ai.NewUserTextMessage("Hello"),
+			ai.NewSystemTextMessage("Be brief"),
+		}}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil,
+			mapping.WithHistoryPolicy(mapping.HistoryPolicy{System: mapping.SystemMessagesMerge}))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(res.Messages))
+		assert.Equal(t, "Be nice\nBe brief", res.Messages[0].Content().String())
+		assert.Equal(t, 1, len(mr.Messages[0].Content))
+	})
+
+	t.Run("should merge consecutive messages", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{Messages: []*ai.Message{
+			ai.NewUserTextMessage("Hello"),
+			ai.NewModelTextMessage("Hi"),
+			ai.NewModelTextMessage("How are you?"),
+			ai.NewUserTextMessage("Fine"),
+			ai.NewUserTextMessage("And you?"),
+		}}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil,
+			mapping.WithHistoryPolicy(mapping.HistoryPolicy{MergeConsecutive: true}))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(res.Messages))
+		assert.Equal(t, "Hi\nHow are you?", res.Messages[1].Content().String())
+		assert.Equal(t, "Fine\nAnd you?", res.Messages[2].Content().String())
+	})
+
+	t.Run("should drop orphan tool responses", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{Messages: []*ai.Message{
+			ai.NewUserTextMessage("Search for cats"),
+			toolRequestMessage("search", "ref1"),
+			ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{Name: "search", Ref: "ref1", Output: "cats"}),
+				ai.NewToolResponsePart(&ai.ToolResponse{Name: "search", Ref: "ref2", Output: "dogs"})),
+			toolResponseMessage("search", "ref3"),
+		}}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil,
+			mapping.WithHistoryPolicy(mapping.HistoryPolicy{DropOrphanToolResponses: true}))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(res.Messages))
+		toolMsg := res.Messages[2].(*mistral.ToolMessage)
+		assert.Equal(t, "ref1", toolMsg.ToolCallId)
+		assert.Equal(t, "cats", toolMsg.Content().String())
+	})
+
+	t.Run("should return an error for orphan tool responses by default", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{Messages: []*ai.Message{
+			ai.NewUserTextMessage("Search for cats"),
+			toolRequestMessage("search", "ref1"),
+			toolResponseMessage("search", "ref2"),
+		}}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorIs(t, err, mapping.ErrOrphanToolResponse)
+	})
+}
@@ -4,8 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"sort"
+	"strings"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/thomas-marquis/mistral-client/mistral"
@@ -13,7 +14,8 @@ import (
 
 var (
 	ErrInvalidRole = errors.New("invalid role")
-	logger         = log.New(os.Stdout, "mistral-client: ", log.LstdFlags|log.Lshortfile)
+
+	discardLogger = slog.New(slog.DiscardHandler)
 )
 
 func StringFromParts(content []*ai.Part) (string, error) {
@@ -41,20 +43,24 @@ func isContentOnlyText(content []*ai.Part) bool {
 	return true
 }
 
-func mapMessageContent(parts []*ai.Part) (mistral.ContentChunks, error) {
+func mapMessageContent(parts []*ai.Part, logger *slog.Logger) (mistral.ContentChunks, error) {
 	content := make(mistral.ContentChunks, 0, len(parts))
 
-	for _, part := range parts {
+	for i, part := range parts {
 		switch part.Kind {
 		case ai.PartText:
 			content = append(content, mistral.NewTextChunk(part.Text))
 		case ai.PartMedia:
-			if part.IsImage() {
+			switch {
+			case part.IsImage():
 				content = append(content, mistral.NewImageUrlChunk(part.Text))
-			} else if part.IsAudio() {
+			case part.IsAudio():
 				content = append(content, mistral.NewAudioChunk(part.Text))
-			} else {
-				logger.Printf("Unsupported media type: %s\n", part.ContentType)
+			case isDocument(part):
+				content = append(content, mistral.NewDocumentUrlChunk(documentName(part), part.Text))
+			default:
+				logger.Warn("Unsupported media type, part ignored",
+					slog.Int("part", i), slog.String("contentType", part.ContentType))
 			}
 		}
 	}
@@ -62,12 +68,74 @@ func mapMessageContent(parts []*ai.Part) (mistral.ContentChunks, error) {
 	return content, nil
 }
 
+func isDocument(part *ai.Part) bool {
+	return strings.HasPrefix(part.ContentType, "application/")
+}
+
+// documentName returns the name given in the part metadata, if any.
+func documentName(part *ai.Part) string {
+	if name, ok := part.Metadata["name"].(string); ok && name != "" {
+		return name
+	}
+	return "document"
+}
+
+// toolOutputContent returns the content sent to Mistral for a tool output.
+// Strings are sent as-is, errors as a {"error": "..."} object and other values are JSON-marshaled.
+func toolOutputContent(output any) (string, error) {
+	switch o := output.(type) {
+	case string:
+		return o, nil
+	case error:
+		output = map[string]any{"error": o.Error()}
+	}
+
+	outputBytes, err := json.Marshal(output)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tool response output: %w", err)
+	}
+	return string(outputBytes), nil
+}
+
+// mapToolResponseContent maps the tool output and the additional parts of a multipart tool response.
+// A tool response with only an output is sent as a string content.
+func mapToolResponseContent(resp *ai.ToolResponse, logger *slog.Logger) (mistral.Content, error) {
+	var output string
+	if resp.Output != nil || len(resp.Content) == 0 {
+		var err error
+		if output, err = toolOutputContent(resp.Output); err != nil {
+			return nil, err
+		}
+	}
+	if len(resp.Content) == 0 {
+		return mistral.ContentString(output), nil
+	}
+
+	chunks, err := mapMessageContent(resp.Content, logger)
+	if err != nil {
+		return nil, err
+	}
+	if output != "" {
+		chunks = append(mistral.ContentChunks{mistral.NewTextChunk(output)}, chunks...)
+	}
+	return chunks, nil
+}
+
 func MapToMistralMessage(msg *ai.Message) ([]mistral.ChatMessage, error) {
+	return mapToMistralMessage(msg, nil, discardLogger)
+}
+
+func mapToMistralMessage(msg *ai.Message, calls *toolCalls, logger *slog.Logger) ([]mistral.ChatMessage, error) {
 	role, err := MapToMistralRole(msg.Role)
 	if err != nil {
 		return nil, err
 	}
 
+	var names *ToolNames
+	if calls != nil {
+		names = calls.names
+	}
+
 	var m []mistral.ChatMessage
 	switch role {
 	case mistral.RoleUser:
@@ -78,7 +146,7 @@ func MapToMistralMessage(msg *ai.Message) ([]mistral.ChatMessage, error) {
 			}
 			m = append(m, mistral.NewUserMessageFromString(strContent))
 		} else {
-			content, err := mapMessageContent(msg.Content)
+			content, err := mapMessageContent(msg.Content, logger)
 			if err != nil {
 				return nil, err
 			}
@@ -94,16 +162,25 @@ func MapToMistralMessage(msg *ai.Message) ([]mistral.ChatMessage, error) {
 			}
 			assMsg = mistral.NewAssistantMessageFromString(strContent)
 		} else {
-			content, err := mapMessageContent(msg.Content)
+			content, err := mapMessageContent(msg.Content, logger)
 			if err != nil {
 				return nil, err
 			}
 			assMsg = mistral.NewAssistantMessage(content)
 		}
-		for i, part := range msg.Content {
+		assMsg.Prefix = isPrefix(msg)
+		index := 0
+		for _, part := range msg.Content {
 			if part.Kind == ai.PartToolRequest {
+				var id string
+				if calls != nil {
+					id = calls.call(part.ToolRequest)
+				} else if id = part.ToolRequest.Ref; id == "" {
+					id = generateToolCallID(part.ToolRequest.Name, index)
+				}
 				assMsg.ToolCalls = append(assMsg.ToolCalls,
-					mistral.NewToolCall(part.ToolRequest.Ref, i, part.ToolRequest.Name, part.ToolRequest.Input))
+					mistral.NewToolCall(id, index, names.ToMistral(part.ToolRequest.Name), part.ToolRequest.Input))
+				index++
 			}
 		}
 		m = append(m, assMsg)
@@ -115,15 +192,43 @@ func MapToMistralMessage(msg *ai.Message) ([]mistral.ChatMessage, error) {
 		m = append(m, mistral.NewSystemMessageFromString(content))
 
 	case mistral.RoleTool:
-		for _, part := range msg.Content {
-			if part.Kind == ai.PartToolResponse {
-				outputBytes, err := json.Marshal(part.ToolResponse.Output)
+		positions := make(map[mistral.ChatMessage]int)
+		var others []*ai.Part
+		for i, part := range msg.Content {
+			if part.Kind != ai.PartToolResponse {
+				others = append(others, part)
+				continue
+			}
+
+			id, position := part.ToolResponse.Ref, i
+			if calls != nil {
+				call, err := calls.respond(part.ToolResponse)
 				if err != nil {
-					return nil, fmt.Errorf("failed to marshal tool response output: %w", err)
+					return nil, err
 				}
-				m = append(m, mistral.NewToolMessage(
-					part.ToolResponse.Name, part.ToolResponse.Ref, mistral.ContentString(outputBytes)))
+				id, position = call.id, call.position
+			}
+
+			content, err := mapToolResponseContent(part.ToolResponse, logger)
+			if err != nil {
+				return nil, err
 			}
+			toolMsg := mistral.NewToolMessage(names.ToMistral(part.ToolResponse.Name), id, content)
+			positions[toolMsg] = position
+			m = append(m, toolMsg)
+		}
+		// Tool responses are sent in the same order as the tool calls they answer.
+		sort.SliceStable(m, func(i, j int) bool {
+			return positions[m[i]] < positions[m[j]]
+		})
+
+		if len(others) > 0 {
+			// Mistral tool messages only hold tool outputs, the other parts follow as a user message.
+			content, err := mapMessageContent(others, logger)
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, mistral.NewUserMessage(content))
 		}
 	}
 
@@ -1,6 +1,7 @@
 package mapping_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
@@ -342,5 +343,101 @@ func TestMapToMistralMessage(t *testing.T) {
 			assert.Equal(t, "inc", toolMsg.Name)
 			assert.Equal(t, "null", toolMsg.Content().String())
 		})
+
+		t.Run("with a raw string output", func(t *testing.T) {
+			// Given
+			genkitMsg := ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{Name: "search", Ref: "ref1", Output: "found"}))
+
+			// When
+			messages, err := mapping.MapToMistralMessage(genkitMsg)
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(messages))
+			assert.Equal(t, mistral.ContentString("found"), messages[0].Content())
+		})
+
+		t.Run("with an error output", func(t *testing.T) {
+			// Given
+			genkitMsg := ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{
+					Name: "search", Ref: "ref1", Output: errors.New("service unavailable")}))
+
+			// When
+			messages, err := mapping.MapToMistralMessage(genkitMsg)
+
+			// Then
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"error": "service unavailable"}`, messages[0].Content().String())
+		})
+
+		t.Run("with a multipart tool response", func(t *testing.T) {
+			// Given
+			genkitMsg := ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{
+					Name:   "screenshot",
+					Ref:    "ref1",
+					Output: map[string]any{"width": 800},
+					Content: []*ai.Part{
+						ai.NewTextPart("The home page"),
+						ai.NewMediaPart("image/png", "https://example.com/home.png"),
+						ai.NewMediaPart("application/pdf", "https://example.com/report.pdf"),
+					},
+				}))
+
+			// When
+			messages, err := mapping.MapToMistralMessage(genkitMsg)
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(messages))
+			toolMsg := messages[0].(*mistral.ToolMessage)
+			assert.Equal(t, "ref1", toolMsg.ToolCallId)
+			assert.Equal(t, mistral.ContentChunks{
+				mistral.NewTextChunk(`{"width":800}`),
+				mistral.NewTextChunk("The home page"),
+				mistral.NewImageUrlChunk("https://example.com/home.png"),
+				mistral.NewDocumentUrlChunk("document", "https://example.com/report.pdf"),
+			}, toolMsg.Content())
+		})
+
+		t.Run("with a multipart tool response without output", func(t *testing.T) {
+			// Given
+			genkitMsg := ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{
+					Name:    "screenshot",
+					Ref:     "ref1",
+					Content: []*ai.Part{ai.NewMediaPart("image/png", "https://example.com/home.png")},
+				}))
+
+			// When
+			messages, err := mapping.MapToMistralMessage(genkitMsg)
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, mistral.ContentChunks{
+				mistral.NewImageUrlChunk("https://example.com/home.png"),
+			}, messages[0].Content())
+		})
+
+		t.Run("with other parts sent as a user message", func(t *testing.T) {
+			// Given
+			genkitMsg := ai.NewMessage(ai.RoleTool, nil,
+				ai.NewToolResponsePart(&ai.ToolResponse{Name: "search", Ref: "ref1", Output: "found"}),
+				ai.NewMediaPart("image/png", "https://example.com/result.png"))
+
+			// When
+			messages, err := mapping.MapToMistralMessage(genkitMsg)
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, 2, len(messages))
+			assert.Equal(t, mistral.RoleTool, messages[0].Role())
+			assert.Equal(t, mistral.RoleUser, messages[1].Role())
+			assert.Equal(t, mistral.ContentChunks{
+				mistral.NewImageUrlChunk("https://example.com/result.png"),
+			}, messages[1].Content())
+		})
 	})
 }
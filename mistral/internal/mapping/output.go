@@ -0,0 +1,88 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// RequestOption customizes the way a Genkit request is mapped to a Mistral one.
+type RequestOption func(o *requestOptions)
+
+type requestOptions struct {
+	jsonObjectOutput bool
+	history          HistoryPolicy
+	logger           *slog.Logger
+}
+
+// WithLogger sets the logger reporting the lossy conversions of the request. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) RequestOption {
+	return func(o *requestOptions) {
+		o.logger = logger
+	}
+}
+
+// WithJSONObjectOutput uses the json_object response format for JSON outputs, even when a schema is provided.
+// It is meant for models that don't support structured outputs with a JSON schema.
+func WithJSONObjectOutput() RequestOption {
+	return func(o *requestOptions) {
+		o.jsonObjectOutput = true
+	}
+}
+
+// outputInstructions returns the instructions Genkit adds to the prompt when the output isn't natively constrained.
+func outputInstructions(schema map[string]any) (string, error) {
+	if schema == nil {
+		return "Output should be in JSON format.", nil
+	}
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal output schema: %w", err)
+	}
+	return fmt.Sprintf("Output should be in JSON format and conform to the following schema:\n\n```%s```", string(b)), nil
+}
+
+// withOutputInstructions returns the messages with the output instructions added to the system message,
+// or to the last user message if there is none. The original messages are left untouched.
+// Nothing is added if the messages already contain output instructions.
+func withOutputInstructions(messages []*ai.Message, instructions string) []*ai.Message {
+	for _, m := range messages {
+		for _, p := range m.Content {
+			if p.Metadata != nil && p.Metadata["purpose"] == "output" {
+				return messages
+			}
+		}
+	}
+
+	target := -1
+	for i, m := range messages {
+		if m.Role == ai.RoleSystem {
+			target = i
+			break
+		}
+	}
+	if target == -1 {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == ai.RoleUser {
+				target = i
+				break
+			}
+		}
+	}
+	if target == -1 {
+		return messages
+	}
+
+	part := ai.NewTextPart(instructions)
+	part.Metadata = map[string]any{"purpose": "output"}
+
+	res := make([]*ai.Message, len(messages))
+	copy(res, messages)
+	msg := *messages[target]
+	msg.Content = append(append([]*ai.Part{}, msg.Content...), part)
+	res[target] = &msg
+
+	return res
+}
@@ -0,0 +1,81 @@
+package mapping
+
+import "encoding/json"
+
+// PatchSchemas replaces the output and tool parameters schemas of a serialized chat completion request
+// with the complete schemas, and its tool choice with the specific function one if any.
+// The other fields are kept as-is.
+func PatchSchemas(body []byte, schemas *Schemas) ([]byte, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	if raw, ok := payload["response_format"]; ok && schemas.Output != nil {
+		var format map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &format); err != nil {
+			return nil, err
+		}
+		if raw, ok := format["json_schema"]; ok {
+			patched, err := setField(raw, "schema", schemas.Output)
+			if err != nil {
+				return nil, err
+			}
+			format["json_schema"] = patched
+			if payload["response_format"], err = json.Marshal(format); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if raw, ok := payload["tools"]; ok && len(schemas.Tools) > 0 {
+		var tools []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &tools); err != nil {
+			return nil, err
+		}
+		for _, tool := range tools {
+			var function struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(tool["function"], &function); err != nil {
+				return nil, err
+			}
+			parameters, ok := schemas.Tools[function.Name]
+			if !ok {
+				continue
+			}
+			patched, err := setField(tool["function"], "parameters", parameters)
+			if err != nil {
+				return nil, err
+			}
+			tool["function"] = patched
+		}
+		var err error
+		if payload["tools"], err = json.Marshal(tools); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := payload["tool_choice"]; ok && schemas.ToolChoice != nil {
+		var err error
+		if payload["tool_choice"], err = json.Marshal(schemas.ToolChoice); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(payload)
+}
+
+// setField sets a field of the serialized JSON object.
+func setField(object json.RawMessage, name string, value any) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(object, &fields); err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	fields[name] = b
+	return json.Marshal(fields)
+}
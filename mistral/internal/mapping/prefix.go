@@ -0,0 +1,67 @@
+package mapping
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// MetadataPrefix is the message metadata key flagging the last model message as the beginning of the response.
+const MetadataPrefix = "prefix"
+
+var ErrInvalidPrefix = errors.New("a response prefix must be a final text model message, or be followed by its response")
+
+func isPrefix(msg *ai.Message) bool {
+	prefix, _ := msg.Metadata[MetadataPrefix].(bool)
+	return prefix
+}
+
+// resolvePrefixes validates the prefix messages and drops the ones of the previous turns.
+// In a continued conversation, e.g. built from the history of a response, a prefix message is followed by the response
+// it started, which already includes it: only the final prefix message is sent.
+func resolvePrefixes(messages []*ai.Message) ([]*ai.Message, error) {
+	res := make([]*ai.Message, 0, len(messages))
+	for i, msg := range messages {
+		if !isPrefix(msg) {
+			res = append(res, msg)
+			continue
+		}
+		if msg.Role != ai.RoleModel || !isContentOnlyText(msg.Content) {
+			return nil, ErrInvalidPrefix
+		}
+		if i == len(messages)-1 {
+			res = append(res, msg)
+			continue
+		}
+		if messages[i+1].Role != ai.RoleModel {
+			return nil, ErrInvalidPrefix
+		}
+	}
+	return res, nil
+}
+
+// ResponsePrefix returns the text the response must start with, or an empty string without prefix message.
+func ResponsePrefix(mr *ai.ModelRequest) string {
+	if mr == nil || len(mr.Messages) == 0 {
+		return ""
+	}
+	last := mr.Messages[len(mr.Messages)-1]
+	if last.Role != ai.RoleModel || !isPrefix(last) {
+		return ""
+	}
+	return last.Text()
+}
+
+// withPrefix makes sure the response text starts with the prefix.
+func withPrefix(parts []*ai.Part, prefix string) []*ai.Part {
+	for i, part := range parts {
+		if part.IsText() {
+			if !strings.HasPrefix(part.Text, prefix) {
+				parts[i] = ai.NewTextPart(prefix + part.Text)
+			}
+			return parts
+		}
+	}
+	return append(parts, ai.NewTextPart(prefix))
+}
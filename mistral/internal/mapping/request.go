@@ -1,30 +1,88 @@
 package mapping
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/thomas-marquis/mistral-client/mistral"
 )
 
 var (
-	ErrNoModelProvided = errors.New("model name is empty")
-	ErrNoMessages      = errors.New("message list is empty")
+	ErrNoModelProvided   = errors.New("model name is empty")
+	ErrNoMessages        = errors.New("message list is empty")
+	ErrUnknownTool       = errors.New("forced tool is not part of the request tools")
+	ErrInvalidToolChoice = errors.New("invalid tool choice")
 )
 
-func MapRequestToMistral(model string, mr *ai.ModelRequest, cfg *mistral.CompletionConfig) (*mistral.ChatCompletionRequest, error) {
+// Schemas holds the normalized JSON schemas of a request.
+// mistral.PropertyDefinition only keeps a few of their keywords, so they are meant to replace
+// the ones of the request when it is sent.
+type Schemas struct {
+	// Output is the schema of the json_schema response format, if any.
+	Output map[string]any
+
+	// Tools are the parameters schemas of the tools, by Mistral name.
+	Tools map[string]map[string]any
+
+	// ToolChoice is the specific function the model is forced to call, if any.
+	// mistral-client serializes tool_choice as a plain string, so it replaces the "any" one of the request.
+	ToolChoice map[string]any
+}
+
+// MapRequestToMistral maps the Genkit request to a Mistral one, along with its complete JSON schemas.
+func MapRequestToMistral(
+	model string, mr *ai.ModelRequest, cfg *mistral.CompletionConfig, opts ...RequestOption,
+) (*mistral.ChatCompletionRequest, *Schemas, error) {
 	if model == "" {
-		return nil, ErrNoModelProvided
+		return nil, nil, ErrNoModelProvided
 	}
 	if len(mr.Messages) == 0 {
-		return nil, ErrNoMessages
+		return nil, nil, ErrNoMessages
+	}
+
+	genkitMessages, err := resolvePrefixes(mr.Messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o := requestOptions{logger: discardLogger}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	logger := o.logger.With(slog.String("model", model))
+
+	genkitMessages, err = normalizeHistory(genkitMessages, o.history)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	messages := make([]mistral.ChatMessage, 0, len(mr.Messages))
-	for _, msg := range mr.Messages {
-		m, err := MapToMistralMessage(msg)
+	jsonOutput := mr.Output != nil && mr.Output.Format == ai.OutputFormatJSON
+	schemaOutput := jsonOutput && mr.Output.Constrained && mr.Output.Schema != nil && !o.jsonObjectOutput
+
+	if jsonOutput && !schemaOutput {
+		// The json_object format requires the expected output to be described in the prompt.
+		var schema map[string]any
+		if mr.Output.Constrained {
+			schema = mr.Output.Schema
+		}
+		instructions, err := outputInstructions(schema)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		genkitMessages = withOutputInstructions(genkitMessages, instructions)
+	}
+
+	names := NewToolNames(mr.Tools)
+	calls := newToolCalls(names)
+
+	messages := make([]mistral.ChatMessage, 0, len(genkitMessages))
+	for i, msg := range genkitMessages {
+		m, err := mapToMistralMessage(msg, calls, logger.With(slog.Int("message", i)))
+		if err != nil {
+			return nil, nil, err
 		}
 		messages = append(messages, m...)
 	}
@@ -39,35 +97,96 @@ func MapRequestToMistral(model string, mr *ai.ModelRequest, cfg *mistral.Complet
 	}
 
 	req.Stream = false
+	schemas := &Schemas{}
 
 	if nbTools := len(mr.Tools); nbTools > 0 {
+		choice, forced, err := resolveToolChoice(mr.ToolChoice, req.ToolChoice)
+		if err != nil {
+			return nil, nil, err
+		}
+
 		tools := make([]mistral.Tool, 0, nbTools)
+		schemas.Tools = make(map[string]map[string]any, nbTools)
 		for _, tool := range mr.Tools {
-			tools = append(tools, mistral.NewTool(tool.Name, tool.Description,
-				mistral.NewPropertyDefinition(tool.InputSchema)))
+			mistralName := names.ToMistral(tool.Name)
+			if forced != "" && (forced == tool.Name || forced == mistralName) {
+				schemas.ToolChoice = map[string]any{
+					"type":     "function",
+					"function": map[string]any{"name": mistralName},
+				}
+			}
+			parameters := normalizeSchema(tool.InputSchema, false, "tool "+tool.Name, logger)
+			schemas.Tools[mistralName] = parameters
+			tools = append(tools, mistral.NewTool(mistralName, tool.Description, ToPropertyDefinition(parameters)))
+		}
+		if forced != "" && schemas.ToolChoice == nil {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownTool, forced)
 		}
+
 		mistral.WithTools(tools)(req)
-		req.ToolChoice = mapToMistralToolChoice(mr.ToolChoice)
+		req.ToolChoice = choice
+	} else {
+		req.ParallelToolCalls = false
 	}
 
-	if mr.Output != nil && mr.Output.Constrained && mr.Output.Format == "json" {
-		mistral.WithResponseJsonSchema(mistral.NewPropertyDefinition(mr.Output.Schema))(req)
-	} else {
+	switch {
+	case schemaOutput:
+		schemas.Output = normalizeSchema(mr.Output.Schema, true, "output", logger)
+		mistral.WithResponseJsonSchema(ToPropertyDefinition(schemas.Output))(req)
+	case jsonOutput:
+		mistral.WithResponseJsonObjectFormat()(req)
+	default:
 		mistral.WithResponseTextFormat()(req)
 	}
 
-	return req, nil
+	return req, schemas, nil
 }
 
-func mapToMistralToolChoice(choice ai.ToolChoice) mistral.ToolChoiceType {
+// resolveToolChoice returns the Mistral tool choice matching the Genkit one, falling back on the
+// tool choice of the completion config when Genkit doesn't specify any.
+// When a specific tool is forced, its name is returned too, along with the "any" tool choice.
+func resolveToolChoice(choice ai.ToolChoice, fallback mistral.ToolChoiceType) (mistral.ToolChoiceType, string, error) {
 	switch choice {
 	case ai.ToolChoiceAuto:
-		return mistral.ToolChoiceAuto
+		return mistral.ToolChoiceAuto, "", nil
 	case ai.ToolChoiceRequired:
-		return mistral.ToolChoiceAny
+		return mistral.ToolChoiceAny, "", nil
 	case ai.ToolChoiceNone:
-		return mistral.ToolChoiceNone
+		return mistral.ToolChoiceNone, "", nil
+	case "":
+		return resolveConfigToolChoice(fallback)
 	default:
-		return mistral.ToolChoiceAuto
+		return mistral.ToolChoiceAny, string(choice), nil
+	}
+}
+
+// resolveConfigToolChoice resolves the tool choice of the completion config: one of the Mistral tool choices
+// or a specific function object. Anything else is rejected rather than taken for a tool name.
+func resolveConfigToolChoice(choice mistral.ToolChoiceType) (mistral.ToolChoiceType, string, error) {
+	switch choice {
+	case "":
+		return mistral.ToolChoiceAuto, "", nil
+	case mistral.ToolChoiceAuto, mistral.ToolChoiceAny, mistral.ToolChoiceNone, mistral.ToolChoiceRequired:
+		return choice, "", nil
+	}
+
+	var specific struct {
+		Type     string             `json:"type"`
+		Function mistral.ToolChoice `json:"function"`
+	}
+	if err := json.Unmarshal([]byte(choice), &specific); err == nil &&
+		specific.Type == "function" && specific.Function.Name != "" {
+		return mistral.ToolChoiceAny, specific.Function.Name, nil
+	}
+
+	return "", "", fmt.Errorf("%w: %q", ErrInvalidToolChoice, choice)
+}
+
+func normalizeSchema(schema map[string]any, strict bool, subject string, logger *slog.Logger) map[string]any {
+	n := &SchemaNormalizer{Strict: strict}
+	normalized := n.NormalizeSchema(schema)
+	for _, loss := range n.Losses {
+		logger.Warn("Lossy schema conversion", slog.String("schema", subject), slog.String("loss", loss))
 	}
+	return normalized
 }
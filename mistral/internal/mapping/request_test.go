@@ -1,11 +1,15 @@
 package mapping_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
 	"github.com/thomas-marquis/mistral-client/mistral"
 )
@@ -38,7 +42,7 @@ func TestMapRequestToMistral(t *testing.T) {
 		}
 
 		// When
-		res, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
 
 		// Then
 		assert.NoError(t, err)
@@ -92,7 +96,7 @@ func TestMapRequestToMistral(t *testing.T) {
 		}
 
 		// When
-		res, err := mapping.MapRequestToMistral("mistral-small-latest", mr, cfg)
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, cfg)
 
 		// Then
 		assert.NoError(t, err)
@@ -152,7 +156,7 @@ func TestMapRequestToMistral(t *testing.T) {
 			}
 
 			// When
-			res, err := mapping.MapRequestToMistral("mistral-small-latest", mr, &mistral.CompletionConfig{})
+			res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, &mistral.CompletionConfig{})
 
 			// Then
 			assert.NoError(t, err)
@@ -179,6 +183,158 @@ func TestMapRequestToMistral(t *testing.T) {
 		})
 	}
 
+	t.Run("should force a specific tool", func(t *testing.T) {
+		newRequest := func(choice ai.ToolChoice) *ai.ModelRequest {
+			return &ai.ModelRequest{
+				Messages: []*ai.Message{ai.NewUserTextMessage("What's the weather in Paris?")},
+				Tools: []*ai.ToolDefinition{
+					{Name: "weather/get", Description: "get the weather"},
+					{Name: "news", Description: "get the news"},
+				},
+				ToolChoice: choice,
+			}
+		}
+
+		for _, tc := range []struct {
+			name       string
+			toolChoice ai.ToolChoice
+			cfg        *mistral.CompletionConfig
+		}{
+			{"with the genkit tool name", "weather/get", nil},
+			{"with the sanitized tool name", "weatherget", nil},
+			{"with a config tool choice object", "", &mistral.CompletionConfig{
+				ToolChoice: `{"type": "function", "function": {"name": "weatherget"}}`,
+			}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				// Given
+				mr := newRequest(tc.toolChoice)
+
+				// When
+				res, schemas, err := mapping.MapRequestToMistral("mistral-small-latest", mr, tc.cfg)
+
+				// Then
+				assert.NoError(t, err)
+				assert.Equal(t, mistral.ToolChoiceAny, res.ToolChoice)
+				assert.Equal(t, 2, len(res.Tools))
+				assert.Equal(t, map[string]any{
+					"type":     "function",
+					"function": map[string]any{"name": "weatherget"},
+				}, schemas.ToolChoice)
+			})
+		}
+	})
+
+	t.Run("should use the config tool choice when genkit doesn't specify any", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{ai.NewUserTextMessage("Hello")},
+			Tools:    []*ai.ToolDefinition{{Name: "news"}},
+		}
+		cfg := &mistral.CompletionConfig{ToolChoice: mistral.ToolChoiceNone}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, cfg)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, mistral.ToolChoiceNone, res.ToolChoice)
+		assert.Equal(t, 1, len(res.Tools))
+	})
+
+	t.Run("should generate tool call IDs when references are missing", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Add and increment"),
+				ai.NewModelMessage(
+					ai.NewTextPart("Let me compute"),
+					ai.NewToolRequestPart(&ai.ToolRequest{Name: "add", Input: map[string]any{"a": 1}}),
+					ai.NewToolRequestPart(&ai.ToolRequest{Name: "inc", Input: map[string]any{"x": 1}}),
+				),
+				ai.NewMessage(ai.RoleTool, nil,
+					ai.NewToolResponsePart(&ai.ToolResponse{Name: "inc", Output: 2}),
+					ai.NewToolResponsePart(&ai.ToolResponse{Name: "add", Output: 3}),
+				),
+			},
+			Tools: []*ai.ToolDefinition{{Name: "add"}, {Name: "inc"}},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+		again, _, _ := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 4, len(res.Messages))
+
+		calls := res.Messages[1].(*mistral.AssistantMessage).ToolCalls
+		assert.Equal(t, 2, len(calls))
+		for i, call := range calls {
+			assert.Equal(t, i, call.Index)
+			assert.Regexp(t, "^[a-zA-Z0-9]{9}$", call.ID)
+		}
+		assert.NotEqual(t, calls[0].ID, calls[1].ID)
+		assert.Equal(t, calls, again.Messages[1].(*mistral.AssistantMessage).ToolCalls)
+
+		first := res.Messages[2].(*mistral.ToolMessage)
+		second := res.Messages[3].(*mistral.ToolMessage)
+		assert.Equal(t, "add", first.Name)
+		assert.Equal(t, calls[0].ID, first.ToolCallId)
+		assert.Equal(t, "inc", second.Name)
+		assert.Equal(t, calls[1].ID, second.ToolCallId)
+	})
+
+	t.Run("should keep parallel tool calls only when tools are provided", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{ai.NewUserTextMessage("Hello")},
+		}
+		cfg := &mistral.CompletionConfig{ParallelToolCalls: true}
+
+		// When
+		withoutTools, _, err1 := mapping.MapRequestToMistral("mistral-small-latest", mr, cfg)
+		mr.Tools = []*ai.ToolDefinition{{Name: "news"}}
+		withTools, _, err2 := mapping.MapRequestToMistral("mistral-small-latest", mr, cfg)
+
+		// Then
+		assert.NoError(t, err1)
+		assert.NoError(t, err2)
+		assert.False(t, withoutTools.ParallelToolCalls)
+		assert.True(t, withTools.ParallelToolCalls)
+	})
+
+	t.Run("should sanitize tool names in tools and history", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Search for genkit"),
+				ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{
+					Ref:   "abcdef123",
+					Name:  "myapp/search",
+					Input: map[string]any{"q": "genkit"},
+				})),
+				ai.NewMessage(ai.RoleTool, nil, ai.NewToolResponsePart(&ai.ToolResponse{
+					Ref:    "abcdef123",
+					Name:   "myapp/search",
+					Output: "found",
+				})),
+			},
+			Tools: []*ai.ToolDefinition{
+				{Name: "myapp/search", Description: "search things"},
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "myappsearch", res.Tools[0].Function.Name)
+		assert.Equal(t, "myappsearch", res.Messages[1].(*mistral.AssistantMessage).ToolCalls[0].Function.Name)
+		assert.Equal(t, "myappsearch", res.Messages[2].(*mistral.ToolMessage).Name)
+	})
+
 	t.Run("should return an error", func(t *testing.T) {
 		t.Run("when message list is empty", func(t *testing.T) {
 			// Given
@@ -187,7 +343,7 @@ func TestMapRequestToMistral(t *testing.T) {
 			}
 
 			// When
-			res, err := mapping.MapRequestToMistral("mistral-small-latest", mr, &mistral.CompletionConfig{})
+			res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, &mistral.CompletionConfig{})
 
 			// Then
 			assert.Nil(t, res)
@@ -202,7 +358,7 @@ func TestMapRequestToMistral(t *testing.T) {
 			}
 
 			// When
-			res, err := mapping.MapRequestToMistral("mistral-small-latest", mr, &mistral.CompletionConfig{})
+			res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, &mistral.CompletionConfig{})
 
 			// Then
 			assert.Nil(t, res)
@@ -210,6 +366,58 @@ func TestMapRequestToMistral(t *testing.T) {
 			assert.Equal(t, "message list is empty", err.Error())
 		})
 
+		t.Run("when a tool response doesn't match any tool call", func(t *testing.T) {
+			// Given
+			mr := &ai.ModelRequest{
+				Messages: []*ai.Message{
+					ai.NewUserTextMessage("Hello"),
+					ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{Ref: "abcdef123", Name: "news"})),
+					ai.NewMessage(ai.RoleTool, nil,
+						ai.NewToolResponsePart(&ai.ToolResponse{Ref: "zzzzzz999", Name: "news"})),
+				},
+				Tools: []*ai.ToolDefinition{{Name: "news"}},
+			}
+
+			// When
+			res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+			// Then
+			assert.Nil(t, res)
+			assert.ErrorIs(t, err, mapping.ErrOrphanToolResponse)
+		})
+
+		t.Run("when the forced tool is not part of the request tools", func(t *testing.T) {
+			// Given
+			mr := &ai.ModelRequest{
+				Messages:   []*ai.Message{ai.NewUserTextMessage("Hello")},
+				Tools:      []*ai.ToolDefinition{{Name: "news"}},
+				ToolChoice: "weather",
+			}
+
+			// When
+			res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+			// Then
+			assert.Nil(t, res)
+			assert.ErrorIs(t, err, mapping.ErrUnknownTool)
+		})
+
+		t.Run("when the config tool choice is unknown", func(t *testing.T) {
+			// Given
+			mr := &ai.ModelRequest{
+				Messages: []*ai.Message{ai.NewUserTextMessage("Hello")},
+				Tools:    []*ai.ToolDefinition{{Name: "news"}},
+			}
+			cfg := &mistral.CompletionConfig{ToolChoice: "requred"}
+
+			// When
+			res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, cfg)
+
+			// Then
+			assert.Nil(t, res)
+			assert.ErrorIs(t, err, mapping.ErrInvalidToolChoice)
+		})
+
 		t.Run("when empty model name is provided", func(t *testing.T) {
 			// Given
 			mr := &ai.ModelRequest{
@@ -217,7 +425,7 @@ func TestMapRequestToMistral(t *testing.T) {
 			}
 
 			// When
-			res, err := mapping.MapRequestToMistral("", mr, &mistral.CompletionConfig{})
+			res, _, err := mapping.MapRequestToMistral("", mr, &mistral.CompletionConfig{})
 
 			// Then
 			assert.Nil(t, res)
@@ -255,7 +463,7 @@ func TestMapRequestToMistral(t *testing.T) {
 		}
 
 		// When
-		res, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
 
 		// Then
 		assert.NoError(t, err)
@@ -272,4 +480,243 @@ func TestMapRequestToMistral(t *testing.T) {
 			Strict: true,
 		}, res.ResponseFormat.JsonSchema)
 	})
+
+	t.Run("should return the complete normalized schemas", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{ai.NewUserTextMessage("What's the weather?")},
+			Tools: []*ai.ToolDefinition{{
+				Name: "weather/get",
+				InputSchema: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"unit": map[string]any{"type": "string", "enum": []any{"celsius", "fahrenheit"}},
+					},
+					"required": []any{"unit"},
+				},
+			}},
+			Output: &ai.ModelOutputConfig{
+				Format:      ai.OutputFormatJSON,
+				Constrained: true,
+				Schema: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"temperature": map[string]any{"type": "number"},
+						"note":        map[string]any{"type": "string"},
+					},
+					"required": []any{"temperature"},
+				},
+			},
+		}
+
+		// When
+		_, schemas, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"temperature": map[string]any{"type": "number"},
+				"note":        map[string]any{"type": []any{"string", "null"}},
+			},
+			"required":             []any{"temperature", "note"},
+			"additionalProperties": false,
+		}, schemas.Output)
+		assert.Equal(t, map[string]map[string]any{
+			"weatherget": {
+				"type": "object",
+				"properties": map[string]any{
+					"unit": map[string]any{"type": "string", "enum": []any{"celsius", "fahrenheit"}},
+				},
+				"required": []any{"unit"},
+			},
+		}, schemas.Tools)
+	})
+
+	t.Run("should use json object format and add instructions when the output is not constrained", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Give me a greeting"),
+			},
+			Output: &ai.ModelOutputConfig{
+				Format: ai.OutputFormatJSON,
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, mistral.ResponseFormatJsonObject, res.ResponseFormat.Type)
+		assert.Nil(t, res.ResponseFormat.JsonSchema)
+		assert.Equal(t, 1, len(res.Messages))
+		assert.Contains(t, res.Messages[0].Content().String(), "Output should be in JSON format.")
+		assert.Equal(t, 1, len(mr.Messages[0].Content))
+	})
+
+	t.Run("should not duplicate the output instructions added by Genkit", func(t *testing.T) {
+		// Given
+		instructions := ai.NewTextPart("Respond with JSON.")
+		instructions.Metadata = map[string]any{"purpose": "output"}
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewSystemTextMessage("You are a helpful assistant."),
+				ai.NewUserMessage(ai.NewTextPart("Give me a greeting"), instructions),
+			},
+			Output: &ai.ModelOutputConfig{
+				Format: ai.OutputFormatJSON,
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, mistral.ResponseFormatJsonObject, res.ResponseFormat.Type)
+		assert.Equal(t, "You are a helpful assistant.", res.Messages[0].Content().String())
+		assert.NotContains(t, res.Messages[1].Content().String(), "Output should be in JSON format")
+	})
+
+	t.Run("should describe the schema in the system message with json object output", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewSystemTextMessage("You are a helpful assistant."),
+				ai.NewUserTextMessage("Give me a greeting"),
+			},
+			Output: &ai.ModelOutputConfig{
+				Format:      ai.OutputFormatJSON,
+				Constrained: true,
+				Schema: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"greeting": map[string]any{"type": "string"},
+					},
+				},
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil, mapping.WithJSONObjectOutput())
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, mistral.ResponseFormatJsonObject, res.ResponseFormat.Type)
+		assert.Nil(t, res.ResponseFormat.JsonSchema)
+		assert.Contains(t, res.Messages[0].Content().String(), "conform to the following schema")
+		assert.Contains(t, res.Messages[0].Content().String(), `"greeting"`)
+		assert.Equal(t, "Give me a greeting", res.Messages[1].Content().String())
+	})
+
+	t.Run("should map a final prefix message", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Write a haiku"),
+				ai.NewMessage(ai.RoleModel, map[string]any{mapping.MetadataPrefix: true}, ai.NewTextPart("Autumn")),
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(res.Messages))
+		prefix := res.Messages[1].(*mistral.AssistantMessage)
+		assert.True(t, prefix.Prefix)
+		assert.Equal(t, "Autumn", prefix.Content().String())
+	})
+
+	t.Run("should not flag model messages without prefix metadata", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Hello"),
+				ai.NewModelTextMessage("Hi!"),
+				ai.NewUserTextMessage("How are you?"),
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.False(t, res.Messages[1].(*mistral.AssistantMessage).Prefix)
+	})
+
+	t.Run("should drop the prefix messages followed by their response", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Write a haiku"),
+				ai.NewMessage(ai.RoleModel, map[string]any{mapping.MetadataPrefix: true}, ai.NewTextPart("Autumn")),
+				ai.NewModelTextMessage("Autumn leaves fall"),
+				ai.NewUserTextMessage("Another one"),
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.NoError(t, err)
+		require.Len(t, res.Messages, 3)
+		answer := res.Messages[1].(*mistral.AssistantMessage)
+		assert.False(t, answer.Prefix)
+		assert.Equal(t, "Autumn leaves fall", answer.Content().String())
+		assert.Equal(t, "Another one", res.Messages[2].Content().String())
+	})
+
+	t.Run("should return an error when the prefix message is not the last one", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewUserTextMessage("Write a haiku"),
+				ai.NewMessage(ai.RoleModel, map[string]any{mapping.MetadataPrefix: true}, ai.NewTextPart("Autumn")),
+				ai.NewUserTextMessage("About the sea"),
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil)
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorIs(t, err, mapping.ErrInvalidPrefix)
+	})
+	t.Run("should log the ignored parts with their position", func(t *testing.T) {
+		// Given
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, nil))
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewSystemTextMessage("you are a useful assistant"),
+				ai.NewUserMessage(
+					ai.NewTextPart("What is it?"),
+					ai.NewMediaPart("video/mp4", "data:video/mp4;base64,AAAA"),
+				),
+			},
+		}
+
+		// When
+		res, _, err := mapping.MapRequestToMistral("mistral-small-latest", mr, nil, mapping.WithLogger(logger))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Len(t, res.Messages[1].Content().Chunks(), 1)
+
+		var entry map[string]any
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, "mistral-small-latest", entry["model"])
+		assert.Equal(t, float64(1), entry["message"])
+		assert.Equal(t, float64(1), entry["part"])
+		assert.Equal(t, "video/mp4", entry["contentType"])
+	})
 }
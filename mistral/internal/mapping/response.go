@@ -30,10 +30,14 @@ func MapToGenkitResponse(mr *ai.ModelRequest, resp *mistral.ChatCompletionRespon
 	choice := resp.Choices[0]
 	msg := choice.Message
 	if am := resp.AssistantMessage(); am != nil && len(am.ToolCalls) > 0 {
+		var names *ToolNames
+		if mr != nil {
+			names = NewToolNames(mr.Tools)
+		}
 		for _, call := range am.ToolCalls {
 			parts = append(parts, ai.NewToolRequestPart(&ai.ToolRequest{
 				Input: call.Function.Arguments,
-				Name:  call.Function.Name,
+				Name:  names.ToGenkit(call.Function.Name),
 				Ref:   call.ID,
 			}))
 		}
@@ -50,6 +54,19 @@ func MapToGenkitResponse(mr *ai.ModelRequest, resp *mistral.ChatCompletionRespon
 		}
 	}
 
+	if prefix := ResponsePrefix(mr); prefix != "" {
+		// Callers expect the full response text, prefix included.
+		parts = withPrefix(parts, prefix)
+	}
+
+	if mr != nil && mr.Output != nil && mr.Output.Format == ai.OutputFormatJSON && mr.Output.Schema != nil {
+		for _, part := range parts {
+			if part.IsText() {
+				part.Text = DropOptionalNulls(part.Text, mr.Output.Schema)
+			}
+		}
+	}
+
 	response.Message = &ai.Message{
 		Role:    ai.RoleModel,
 		Content: parts,
@@ -57,6 +74,10 @@ func MapToGenkitResponse(mr *ai.ModelRequest, resp *mistral.ChatCompletionRespon
 
 	response.FinishReason = mapFinishReason(choice.FinishReason)
 
+	if response.Usage != nil {
+		ai.CalculateInputOutputUsage(mr, response)
+	}
+
 	return response, nil
 }
 
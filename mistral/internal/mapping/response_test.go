@@ -27,7 +27,9 @@ func TestMapToGenkitResponse(t *testing.T) {
 			},
 			Latency: 3 * time.Second,
 		}
-		mr := &ai.ModelRequest{}
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{ai.NewUserTextMessage("Hello!")},
+		}
 
 		// When
 		res, err := mapping.MapToGenkitResponse(mr, resp)
@@ -36,6 +38,8 @@ func TestMapToGenkitResponse(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, res)
 		assert.Equal(t, mr, res.Request)
+		assert.Equal(t, len("Hello!"), res.Usage.InputCharacters)
+		assert.Equal(t, len("Hello simple human being!"), res.Usage.OutputCharacters)
 		assert.Equal(t, 100, res.Usage.OutputTokens)
 		assert.Equal(t, 10, res.Usage.InputTokens)
 		assert.Equal(t, 110, res.Usage.TotalTokens)
@@ -124,4 +128,97 @@ func TestMapToGenkitResponse(t *testing.T) {
 		assert.Equal(t, "ref67890", content[1].ToolRequest.Ref)
 		assert.Equal(t, "inc", content[1].ToolRequest.Name)
 	})
+
+	t.Run("should map sanitized tool names back to genkit names", func(t *testing.T) {
+		// Given
+		resp := &mistral.ChatCompletionResponse{
+			Choices: []mistral.ChatCompletionChoice{
+				{
+					Message: mistral.NewAssistantMessageFromString("",
+						mistral.NewToolCall("ref12345", 0, "myappsearch", nil),
+						mistral.NewToolCall("ref67890", 1, "myappsearch_2", nil)),
+					FinishReason: mistral.FinishReasonToolCalls,
+				},
+			},
+		}
+		mr := &ai.ModelRequest{
+			Tools: []*ai.ToolDefinition{
+				{Name: "myapp/search"},
+				{Name: "myapp.search"},
+			},
+		}
+
+		// When
+		res, err := mapping.MapToGenkitResponse(mr, resp)
+
+		// Then
+		assert.NoError(t, err)
+		content := res.Message.Content
+		assert.Equal(t, 2, len(content))
+		assert.Equal(t, "myapp/search", content[0].ToolRequest.Name)
+		assert.Equal(t, "myapp.search", content[1].ToolRequest.Name)
+	})
+
+	t.Run("should merge the prefix into the response text", func(t *testing.T) {
+		for _, tc := range []struct {
+			name    string
+			content string
+		}{
+			{"when the response continues the prefix", " leaves fall"},
+			{"when the response already starts with the prefix", "Autumn leaves fall"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				// Given
+				mr := &ai.ModelRequest{
+					Messages: []*ai.Message{
+						ai.NewUserTextMessage("Write a haiku"),
+						ai.NewMessage(ai.RoleModel, map[string]any{mapping.MetadataPrefix: true}, ai.NewTextPart("Autumn")),
+					},
+				}
+				resp := &mistral.ChatCompletionResponse{
+					Choices: []mistral.ChatCompletionChoice{
+						{Message: mistral.NewAssistantMessageFromString(tc.content)},
+					},
+				}
+
+				// When
+				res, err := mapping.MapToGenkitResponse(mr, resp)
+
+				// Then
+				assert.NoError(t, err)
+				assert.Equal(t, "Autumn leaves fall", res.Text())
+			})
+		}
+	})
+
+	t.Run("should drop the null values of the optional properties of a JSON output", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{ai.NewUserTextMessage("What's the weather?")},
+			Output: &ai.ModelOutputConfig{
+				Format:      ai.OutputFormatJSON,
+				Constrained: true,
+				Schema: map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"temperature": map[string]any{"type": []any{"number", "null"}},
+						"note":        map[string]any{"type": "string"},
+					},
+					"required": []any{"temperature"},
+				},
+			},
+		}
+		resp := &mistral.ChatCompletionResponse{
+			Choices: []mistral.ChatCompletionChoice{
+				{Message: mistral.NewAssistantMessageFromString(`{"temperature": null, "note": null}`)},
+			},
+		}
+
+		// When
+		res, err := mapping.MapToGenkitResponse(mr, resp)
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, `{"temperature":null}`, res.Text())
+	})
 }
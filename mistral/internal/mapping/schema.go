@@ -0,0 +1,488 @@
+package mapping
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+// metadataKeywords are removed from the schemas without loss of information.
+var metadataKeywords = []string{"$schema", "$id", "$comment", "$anchor", "$defs", "definitions", "examples"}
+
+// hintKeywords are not supported by Mistral. They are removed from the schema and moved into the description
+// so the model can still take them into account.
+var hintKeywords = []string{
+	"format", "pattern",
+	"minLength", "maxLength",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+	"minItems", "maxItems", "uniqueItems",
+	"minProperties", "maxProperties",
+}
+
+// droppedKeywords are not supported by Mistral and can't be expressed otherwise.
+var droppedKeywords = []string{
+	"patternProperties", "propertyNames", "dependentRequired", "dependentSchemas",
+	"if", "then", "else", "not", "contains",
+}
+
+// SchemaNormalizer rewrites Genkit JSON schemas into schemas Mistral accepts.
+// Every change losing information is recorded in Losses.
+type SchemaNormalizer struct {
+	// Strict enforces the structured output strict mode requirements:
+	// every property is required (optional ones become nullable) and objects don't accept additional properties.
+	Strict bool
+
+	// Losses lists the lossy conversions, with the JSON pointer of the impacted schema.
+	Losses []string
+
+	root map[string]any
+}
+
+// NormalizeSchema returns a copy of the schema where references are inlined and unsupported keywords are rewritten.
+// The input schema is left untouched.
+func (n *SchemaNormalizer) NormalizeSchema(schema map[string]any) map[string]any {
+	if schema == nil {
+		return nil
+	}
+	// The normalization appends to the enum and type slices, which may share the caller's backing arrays.
+	schema = deepCopy(schema).(map[string]any)
+	n.root = schema
+	normalized := n.normalize(schema, "#", nil)
+	sort.Strings(n.Losses)
+	return normalized
+}
+
+func (n *SchemaNormalizer) lose(path, format string, args ...any) {
+	n.Losses = append(n.Losses, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+// normalize rewrites a schema. expanding holds the references being inlined, to detect recursive ones.
+func (n *SchemaNormalizer) normalize(schema map[string]any, path string, expanding []string) map[string]any {
+	out := make(map[string]any, len(schema))
+	for k, v := range schema {
+		out[k] = v
+	}
+
+	if ref, ok := out["$ref"].(string); ok {
+		delete(out, "$ref")
+		resolved := n.resolve(ref, path, expanding)
+		for k, v := range resolved {
+			if _, exists := out[k]; !exists {
+				out[k] = v
+			}
+		}
+		expanding = append(expanding[:len(expanding):len(expanding)], ref)
+	}
+
+	for _, k := range metadataKeywords {
+		delete(out, k)
+	}
+
+	if c, ok := out["const"]; ok {
+		delete(out, "const")
+		out["enum"] = []any{c}
+	}
+
+	if nullable, ok := out["nullable"].(bool); ok {
+		delete(out, "nullable")
+		if nullable {
+			makeNullable(out)
+		}
+	}
+
+	n.moveHints(out, path)
+
+	for _, k := range sortedKeys(out) {
+		if contains(droppedKeywords, k) {
+			delete(out, k)
+			n.lose(path, "unsupported keyword %q removed", k)
+		}
+	}
+
+	if oneOf, ok := out["oneOf"]; ok {
+		delete(out, "oneOf")
+		out["anyOf"] = oneOf
+		n.lose(path, "oneOf rewritten as anyOf, exclusivity is not enforced")
+	}
+	if allOf, ok := out["allOf"].([]any); ok {
+		delete(out, "allOf")
+		n.mergeAllOf(out, allOf, path, expanding)
+	}
+
+	if anyOf, ok := out["anyOf"].([]any); ok {
+		normalized := make([]any, 0, len(anyOf))
+		for i, sub := range anyOf {
+			if m, ok := sub.(map[string]any); ok {
+				normalized = append(normalized, n.normalize(m, fmt.Sprintf("%s/anyOf/%d", path, i), expanding))
+			} else {
+				normalized = append(normalized, sub)
+			}
+		}
+		out["anyOf"] = normalized
+	}
+
+	if items, ok := out["items"].(map[string]any); ok {
+		out["items"] = n.normalize(items, path+"/items", expanding)
+	} else if _, ok := out["items"].(bool); ok {
+		out["items"] = map[string]any{}
+	}
+
+	if props, ok := out["properties"].(map[string]any); ok {
+		normalized := make(map[string]any, len(props))
+		for name, prop := range props {
+			if m, ok := prop.(map[string]any); ok {
+				normalized[name] = n.normalize(m, path+"/properties/"+name, expanding)
+			} else {
+				normalized[name] = prop
+			}
+		}
+		out["properties"] = normalized
+	}
+
+	if isObject(out) {
+		n.normalizeObject(out, path)
+	}
+
+	return out
+}
+
+func (n *SchemaNormalizer) resolve(ref, path string, expanding []string) map[string]any {
+	if contains(expanding, ref) {
+		n.lose(path, "recursive reference %q cut, replaced by a generic object", ref)
+		return map[string]any{"type": "object"}
+	}
+
+	var target any = n.root
+	if !strings.HasPrefix(ref, "#") {
+		n.lose(path, "external reference %q not supported, replaced by a generic object", ref)
+		return map[string]any{"type": "object"}
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := target.(map[string]any)
+		if !ok {
+			target = nil
+			break
+		}
+		target = m[token]
+	}
+
+	resolved, ok := target.(map[string]any)
+	if !ok {
+		n.lose(path, "unresolved reference %q replaced by a generic object", ref)
+		return map[string]any{"type": "object"}
+	}
+	return resolved
+}
+
+func (n *SchemaNormalizer) moveHints(schema map[string]any, path string) {
+	var hints []string
+	for _, k := range hintKeywords {
+		if v, ok := schema[k]; ok {
+			hints = append(hints, fmt.Sprintf("%s: %v", k, v))
+			delete(schema, k)
+			n.lose(path, "unsupported keyword %q moved into the description", k)
+		}
+	}
+	if len(hints) == 0 {
+		return
+	}
+	desc, _ := schema["description"].(string)
+	if desc != "" {
+		desc += " "
+	}
+	schema["description"] = desc + "(" + strings.Join(hints, ", ") + ")"
+}
+
+func (n *SchemaNormalizer) mergeAllOf(schema map[string]any, allOf []any, path string, expanding []string) {
+	props, _ := schema["properties"].(map[string]any)
+	if props == nil {
+		props = make(map[string]any)
+	}
+	required := toStrings(schema["required"])
+
+	for i, sub := range allOf {
+		m, ok := sub.(map[string]any)
+		if !ok {
+			continue
+		}
+		m = n.normalize(m, fmt.Sprintf("%s/allOf/%d", path, i), expanding)
+		if !isObject(m) {
+			n.lose(path, "non object allOf member %d removed", i)
+			continue
+		}
+		if subProps, ok := m["properties"].(map[string]any); ok {
+			for k, v := range subProps {
+				props[k] = v
+			}
+		}
+		required = append(required, toStrings(m["required"])...)
+		if d, ok := m["description"].(string); ok {
+			if _, exists := schema["description"]; !exists {
+				schema["description"] = d
+			}
+		}
+	}
+
+	schema["type"] = "object"
+	schema["properties"] = props
+	if len(required) > 0 {
+		schema["required"] = toAny(dedup(required))
+	}
+}
+
+func (n *SchemaNormalizer) normalizeObject(schema map[string]any, path string) {
+	switch ap := schema["additionalProperties"].(type) {
+	case map[string]any:
+		if len(ap) > 0 {
+			n.lose(path, "typed additionalProperties not supported, replaced by %v", !n.Strict)
+		}
+		schema["additionalProperties"] = !n.Strict
+	case bool:
+		if ap && n.Strict {
+			n.lose(path, "additionalProperties not allowed in strict mode")
+			schema["additionalProperties"] = false
+		}
+	default:
+		if n.Strict {
+			schema["additionalProperties"] = false
+		}
+	}
+
+	if !n.Strict {
+		return
+	}
+
+	props, _ := schema["properties"].(map[string]any)
+	required := toStrings(schema["required"])
+	for _, name := range sortedKeys(props) {
+		if contains(required, name) {
+			continue
+		}
+		if m, ok := props[name].(map[string]any); ok {
+			makeNullable(m)
+		}
+		required = append(required, name)
+	}
+	if len(props) > 0 {
+		schema["required"] = toAny(required)
+	}
+}
+
+// ToPropertyDefinition converts a normalized schema into the mistral-client PropertyDefinition.
+// Only the keywords supported by PropertyDefinition are kept. Union types keep their first non-null type.
+// The definition is a fallback: the plugin client sends the normalized schema itself (see Schemas).
+func ToPropertyDefinition(schema map[string]any) mistral.PropertyDefinition {
+	return mistral.NewPropertyDefinition(flattenTypes(schema))
+}
+
+func flattenTypes(schema map[string]any) map[string]any {
+	if schema == nil {
+		return nil
+	}
+	out := make(map[string]any, len(schema))
+	for k, v := range schema {
+		out[k] = v
+	}
+	if types, ok := schema["type"].([]any); ok {
+		out["type"] = firstNonNullType(types)
+	}
+	if props, ok := schema["properties"].(map[string]any); ok {
+		mapped := make(map[string]any, len(props))
+		for k, v := range props {
+			if m, ok := v.(map[string]any); ok {
+				mapped[k] = flattenTypes(m)
+			} else {
+				mapped[k] = v
+			}
+		}
+		out["properties"] = mapped
+	}
+	return out
+}
+
+// DropOptionalNulls removes from the JSON output the null values of the properties the schema doesn't require.
+// The strict mode makes the optional properties nullable and required, while the output is validated
+// against the original schema. Outputs which aren't valid JSON are returned as-is.
+func DropOptionalNulls(output string, schema map[string]any) string {
+	dec := json.NewDecoder(strings.NewReader(output))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil || dec.More() {
+		return output
+	}
+	// References are inlined, but the required properties are kept as-is.
+	inlined := (&SchemaNormalizer{}).NormalizeSchema(schema)
+	if !dropNulls(value, inlined) {
+		return output
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return output
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func dropNulls(value any, schema map[string]any) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		required := toStrings(schema["required"])
+		for name, prop := range v {
+			propSchema, declared := props[name].(map[string]any)
+			if prop == nil && declared && !contains(required, name) {
+				delete(v, name)
+				changed = true
+				continue
+			}
+			changed = dropNulls(prop, propSchema) || changed
+		}
+	case []any:
+		items, _ := schema["items"].(map[string]any)
+		for _, item := range v {
+			changed = dropNulls(item, items) || changed
+		}
+	}
+	return changed
+}
+
+func firstNonNullType(types []any) string {
+	for _, t := range types {
+		if s, ok := t.(string); ok && s != "null" {
+			return s
+		}
+	}
+	return "null"
+}
+
+func makeNullable(schema map[string]any) {
+	if enum, ok := schema["enum"].([]any); ok && !containsNil(enum) {
+		schema["enum"] = append(enum, nil)
+	}
+
+	switch t := schema["type"].(type) {
+	case string:
+		if t != "null" {
+			schema["type"] = []any{t, "null"}
+		}
+	case []any:
+		for _, v := range t {
+			if v == "null" {
+				return
+			}
+		}
+		schema["type"] = append(t, "null")
+	default:
+		if anyOf, ok := schema["anyOf"].([]any); ok {
+			schema["anyOf"] = append(anyOf, map[string]any{"type": "null"})
+		}
+	}
+}
+
+// deepCopy copies the maps and slices of a decoded JSON value.
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, sub := range v {
+			out[k] = deepCopy(sub)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, sub := range v {
+			out[i] = deepCopy(sub)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func containsNil(values []any) bool {
+	for _, v := range values {
+		if v == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isObject(schema map[string]any) bool {
+	switch t := schema["type"].(type) {
+	case string:
+		return t == "object"
+	case []any:
+		for _, v := range t {
+			if v == "object" {
+				return true
+			}
+		}
+	}
+	_, hasProps := schema["properties"]
+	return hasProps && schema["type"] == nil
+}
+
+func toStrings(v any) []string {
+	switch t := v.(type) {
+	case []string:
+		return append([]string(nil), t...)
+	case []any:
+		res := make([]string, 0, len(t))
+		for _, s := range t {
+			if str, ok := s.(string); ok {
+				res = append(res, str)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func toAny(values []string) []any {
+	res := make([]any, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
+
+func dedup(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,301 @@
+package mapping_test
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/core"
+	"github.com/invopop/jsonschema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+type address struct {
+	Street  string `json:"street"`
+	City    string `json:"city"`
+	ZipCode string `json:"zip_code,omitempty" jsonschema:"pattern=^[0-9]{5}$"`
+}
+
+type customer struct {
+	Name     string   `json:"name" jsonschema:"description=Full name of the customer"`
+	Email    string   `json:"email" jsonschema:"format=email"`
+	Age      int      `json:"age,omitempty" jsonschema:"minimum=18,maximum=130"`
+	Billing  address  `json:"billing"`
+	Shipping *address `json:"shipping,omitempty"`
+}
+
+type orderLine struct {
+	Product  string  `json:"product"`
+	Quantity int     `json:"quantity" jsonschema:"minimum=1"`
+	Price    float64 `json:"price"`
+}
+
+type order struct {
+	ID         string            `json:"id"`
+	Customer   customer          `json:"customer"`
+	Lines      []orderLine       `json:"lines" jsonschema:"minItems=1"`
+	Status     string            `json:"status" jsonschema:"enum=pending,enum=shipped,enum=delivered"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type category struct {
+	Name     string      `json:"name"`
+	Children []*category `json:"children,omitempty"`
+}
+
+type recipe struct {
+	Title       string
+	Ingredients []string
+	Steps       []string
+}
+
+func reflectSchema(t *testing.T, v any) map[string]any {
+	t.Helper()
+	r := jsonschema.Reflector{}
+	b, err := json.Marshal(r.Reflect(v))
+	require.NoError(t, err)
+	var m map[string]any
+	require.NoError(t, json.Unmarshal(b, &m))
+	return m
+}
+
+func TestSchemaNormalizer_Golden(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		schema func(t *testing.T) map[string]any
+		strict bool
+	}{
+		{"order_strict", func(t *testing.T) map[string]any { return reflectSchema(t, &order{}) }, true},
+		{"order_tool", func(t *testing.T) map[string]any { return reflectSchema(t, &order{}) }, false},
+		{"category_strict", func(t *testing.T) map[string]any { return reflectSchema(t, &category{}) }, true},
+		{"recipe_genkit_strict", func(t *testing.T) map[string]any { return core.InferSchemaMap(recipe{}) }, true},
+	} {
+		t.Run("should match golden file "+tc.name, func(t *testing.T) {
+			// Given
+			schema := tc.schema(t)
+			n := &mapping.SchemaNormalizer{Strict: tc.strict}
+
+			// When
+			normalized := n.NormalizeSchema(schema)
+
+			// Then
+			got, err := json.MarshalIndent(map[string]any{
+				"schema": normalized,
+				"losses": n.Losses,
+			}, "", "  ")
+			require.NoError(t, err)
+
+			path := filepath.Join("testdata", "schemas", tc.name+".golden.json")
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(path, append(got, '\n'), 0o644))
+			}
+			expected, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(got))
+		})
+	}
+}
+
+func TestSchemaNormalizer(t *testing.T) {
+	t.Run("should inline references and remove definitions", func(t *testing.T) {
+		// Given
+		schema := map[string]any{
+			"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"$ref":    "#/$defs/point",
+			"$defs": map[string]any{
+				"point": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"x": map[string]any{"type": "number"},
+					},
+					"required": []any{"x"},
+				},
+			},
+		}
+		n := &mapping.SchemaNormalizer{}
+
+		// When
+		got := n.NormalizeSchema(schema)
+
+		// Then
+		assert.Equal(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"x": map[string]any{"type": "number"},
+			},
+			"required": []any{"x"},
+		}, got)
+		assert.Empty(t, n.Losses)
+		assert.Contains(t, schema, "$defs")
+	})
+
+	t.Run("should make optional properties nullable and required in strict mode", func(t *testing.T) {
+		// Given
+		schema := map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"name":     map[string]any{"type": "string"},
+				"nickname": map[string]any{"type": "string"},
+				"level":    map[string]any{"type": "string", "enum": []any{"low", "high"}},
+			},
+			"required": []any{"name"},
+		}
+		n := &mapping.SchemaNormalizer{Strict: true}
+
+		// When
+		got := n.NormalizeSchema(schema)
+
+		// Then
+		assert.Equal(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"name":     map[string]any{"type": "string"},
+				"nickname": map[string]any{"type": []any{"string", "null"}},
+				"level":    map[string]any{"type": []any{"string", "null"}, "enum": []any{"low", "high", nil}},
+			},
+			"required":             []any{"name", "level", "nickname"},
+			"additionalProperties": false,
+		}, got)
+	})
+
+	t.Run("should leave the input schema untouched", func(t *testing.T) {
+		// Given
+		// The spare capacity of the slices holds a sentinel, overwritten by an in place append.
+		enum := []any{"low", "high", "sentinel"}[:2]
+		types := []any{"string", "sentinel"}[:1]
+		schema := map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"level": map[string]any{"type": "string", "enum": enum},
+				"note":  map[string]any{"type": types},
+			},
+		}
+		n := &mapping.SchemaNormalizer{Strict: true}
+
+		// When
+		got := n.NormalizeSchema(schema)
+
+		// Then
+		assert.Equal(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"level": map[string]any{"type": "string", "enum": []any{"low", "high"}},
+				"note":  map[string]any{"type": []any{"string"}},
+			},
+		}, schema)
+		assert.Equal(t, "sentinel", enum[:cap(enum)][2])
+		assert.Equal(t, "sentinel", types[:cap(types)][1])
+		properties := got["properties"].(map[string]any)
+		assert.Equal(t, []any{"low", "high", nil}, properties["level"].(map[string]any)["enum"])
+		assert.Equal(t, []any{"string", "null"}, properties["note"].(map[string]any)["type"])
+	})
+
+	t.Run("should rewrite unsupported keywords and report losses", func(t *testing.T) {
+		// Given
+		schema := map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"email": map[string]any{"type": "string", "format": "email", "description": "contact"},
+				"kind":  map[string]any{"oneOf": []any{map[string]any{"type": "string"}, map[string]any{"type": "integer"}}},
+				"tags": map[string]any{
+					"type":                 "object",
+					"additionalProperties": map[string]any{"type": "string"},
+				},
+				"fixed": map[string]any{"const": "v1"},
+			},
+		}
+		n := &mapping.SchemaNormalizer{}
+
+		// When
+		got := n.NormalizeSchema(schema)
+
+		// Then
+		props := got["properties"].(map[string]any)
+		assert.Equal(t, map[string]any{"type": "string", "description": "contact (format: email)"}, props["email"])
+		assert.Equal(t, map[string]any{"anyOf": []any{map[string]any{"type": "string"}, map[string]any{"type": "integer"}}}, props["kind"])
+		assert.Equal(t, map[string]any{"type": "object", "additionalProperties": true}, props["tags"])
+		assert.Equal(t, map[string]any{"enum": []any{"v1"}}, props["fixed"])
+		assert.Equal(t, []string{
+			`#/properties/email: unsupported keyword "format" moved into the description`,
+			`#/properties/kind: oneOf rewritten as anyOf, exclusivity is not enforced`,
+			`#/properties/tags: typed additionalProperties not supported, replaced by true`,
+		}, n.Losses)
+	})
+
+	t.Run("should merge allOf object members", func(t *testing.T) {
+		// Given
+		schema := map[string]any{
+			"allOf": []any{
+				map[string]any{"type": "object", "properties": map[string]any{"a": map[string]any{"type": "string"}}, "required": []any{"a"}},
+				map[string]any{"type": "object", "properties": map[string]any{"b": map[string]any{"type": "integer"}}},
+			},
+		}
+		n := &mapping.SchemaNormalizer{}
+
+		// When
+		got := n.NormalizeSchema(schema)
+
+		// Then
+		assert.Equal(t, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"a": map[string]any{"type": "string"},
+				"b": map[string]any{"type": "integer"},
+			},
+			"required": []any{"a"},
+		}, got)
+	})
+
+	t.Run("should cut recursive references", func(t *testing.T) {
+		// Given
+		schema := map[string]any{
+			"$ref": "#/$defs/node",
+			"$defs": map[string]any{
+				"node": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"child": map[string]any{"$ref": "#/$defs/node"},
+					},
+				},
+			},
+		}
+		n := &mapping.SchemaNormalizer{}
+
+		// When
+		got := n.NormalizeSchema(schema)
+
+		// Then
+		assert.Equal(t, "object", got["type"])
+		assert.Len(t, n.Losses, 1)
+		assert.Contains(t, n.Losses[0], "recursive reference")
+	})
+}
+
+func TestToPropertyDefinition(t *testing.T) {
+	t.Run("should keep the first non null type of unions in the fallback definition", func(t *testing.T) {
+		// Given
+		schema := map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"nickname": map[string]any{"type": []any{"string", "null"}},
+			},
+		}
+
+		// When
+		got := mapping.ToPropertyDefinition(schema)
+
+		// Then
+		assert.Equal(t, mistral.NewObjectPropertyDefinition(map[string]mistral.PropertyDefinition{
+			"nickname": {Type: "string"},
+		}), got)
+	})
+}
@@ -0,0 +1,215 @@
+package mapping
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+var (
+	ErrOrphanToolResponse = errors.New("tool response doesn't match any tool call")
+)
+
+const (
+	maxToolNameLength = 256
+	defaultToolName   = "tool"
+)
+
+// SanitizeToolName formats a function name to be used as a reference in a tool call.
+func SanitizeToolName(name string) string {
+	runes := []rune(name)
+
+	isAllowed := func(r rune) bool {
+		return (r >= 'a' && r <= 'z') ||
+			(r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') ||
+			r == '_' || r == '-'
+	}
+
+	var b strings.Builder
+	b.Grow(len(runes))
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_':
+			b.WriteRune(r)
+			i++
+		case r == '-':
+			// If pattern is "-<disallowed>-" convert to "_-" (skip the middle disallowed runes)
+			j := i + 1
+			skipped := 0
+			for j < len(runes) && !isAllowed(runes[j]) {
+				j++
+				skipped++
+			}
+			if j < len(runes) && runes[j] == '-' && skipped > 0 {
+				b.WriteRune('_')
+				b.WriteRune('-')
+				i = j + 1
+			} else {
+				b.WriteRune('-')
+				i++
+			}
+		default:
+			// drop any other disallowed characters
+			i++
+		}
+	}
+
+	result := b.String()
+	if len(result) > maxToolNameLength {
+		result = result[:maxToolNameLength]
+	}
+	return result
+}
+
+// ToolNames keeps the correspondence between Genkit tool names and the sanitized names sent to Mistral.
+// It is built from the tools of a single request, so both directions of the mapping stay consistent.
+type ToolNames struct {
+	toMistral map[string]string
+	toGenkit  map[string]string
+}
+
+// NewToolNames sanitizes the names of the given tools.
+// When two tools sanitize to the same name, a numeric suffix is appended to keep them distinct.
+func NewToolNames(tools []*ai.ToolDefinition) *ToolNames {
+	n := &ToolNames{
+		toMistral: make(map[string]string, len(tools)),
+		toGenkit:  make(map[string]string, len(tools)),
+	}
+
+	for _, tool := range tools {
+		if _, ok := n.toMistral[tool.Name]; ok {
+			continue
+		}
+
+		base := SanitizeToolName(tool.Name)
+		if base == "" {
+			base = defaultToolName
+		}
+
+		name := base
+		for i := 2; ; i++ {
+			if _, taken := n.toGenkit[name]; !taken {
+				break
+			}
+			suffix := "_" + strconv.Itoa(i)
+			if len(base)+len(suffix) > maxToolNameLength {
+				name = base[:maxToolNameLength-len(suffix)] + suffix
+			} else {
+				name = base + suffix
+			}
+		}
+
+		n.toMistral[tool.Name] = name
+		n.toGenkit[name] = tool.Name
+	}
+
+	return n
+}
+
+// ToMistral returns the name to send to Mistral for the given Genkit tool name.
+// Unknown names are sanitized on the fly.
+func (n *ToolNames) ToMistral(name string) string {
+	if n != nil {
+		if mistralName, ok := n.toMistral[name]; ok {
+			return mistralName
+		}
+	}
+	return SanitizeToolName(name)
+}
+
+// ToGenkit returns the original Genkit tool name for a name returned by Mistral.
+// Unknown names are returned as is.
+func (n *ToolNames) ToGenkit(name string) string {
+	if n != nil {
+		if genkitName, ok := n.toGenkit[name]; ok {
+			return genkitName
+		}
+	}
+	return name
+}
+
+const toolCallIDLength = 9
+
+const toolCallIDAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// toolCalls pairs the tool calls of the assistant messages with the tool responses of a request.
+type toolCalls struct {
+	names   *ToolNames
+	pending []*pendingToolCall
+	count   int
+}
+
+type pendingToolCall struct {
+	ref      string
+	name     string
+	id       string
+	position int
+}
+
+func newToolCalls(names *ToolNames) *toolCalls {
+	return &toolCalls{names: names}
+}
+
+// call registers a tool call and returns the ID to send to Mistral.
+// A missing reference is replaced with a stable, Mistral compliant generated ID.
+func (t *toolCalls) call(req *ai.ToolRequest) string {
+	id := req.Ref
+	if id == "" {
+		id = generateToolCallID(req.Name, t.count)
+	}
+	t.pending = append(t.pending, &pendingToolCall{ref: req.Ref, name: req.Name, id: id, position: t.count})
+	t.count++
+	return id
+}
+
+// respond finds the call the tool response answers and returns it.
+// Responses with a reference match the call with the same reference,
+// others match the oldest pending call to the same tool without reference.
+func (t *toolCalls) respond(resp *ai.ToolResponse) (*pendingToolCall, error) {
+	for i, call := range t.pending {
+		if call.name != resp.Name || call.ref != resp.Ref {
+			continue
+		}
+		t.pending = append(t.pending[:i], t.pending[i+1:]...)
+		return call, nil
+	}
+	return nil, fmt.Errorf("%w: no call to tool %q with reference %q", ErrOrphanToolResponse, resp.Name, resp.Ref)
+}
+
+// generateToolCallID derives a 9 alphanumeric characters ID from the tool name and the call position,
+// so the same history always produces the same IDs.
+func generateToolCallID(name string, position int) string {
+	sum := sha256.Sum256([]byte(name + "#" + strconv.Itoa(position)))
+	id := make([]byte, toolCallIDLength)
+	for i := range id {
+		id[i] = toolCallIDAlphabet[int(sum[i])%len(toolCallIDAlphabet)]
+	}
+	return string(id)
+}
+
+// LimitToolRequests keeps only the first tool request of the message.
+// It is used to honor disabled parallel tool calls.
+func LimitToolRequests(msg *ai.Message) {
+	if msg == nil {
+		return
+	}
+	content := make([]*ai.Part, 0, len(msg.Content))
+	found := false
+	for _, part := range msg.Content {
+		if part.IsToolRequest() {
+			if found {
+				continue
+			}
+			found = true
+		}
+		content = append(content, part)
+	}
+	msg.Content = content
+}
@@ -0,0 +1,111 @@
+package mapping_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+)
+
+func TestNewToolNames(t *testing.T) {
+	t.Run("should sanitize tool names and map them back", func(t *testing.T) {
+		// Given
+		tools := []*ai.ToolDefinition{
+			{Name: "myapp/search"},
+			{Name: "weather.get"},
+		}
+
+		// When
+		names := mapping.NewToolNames(tools)
+
+		// Then
+		assert.Equal(t, "myappsearch", names.ToMistral("myapp/search"))
+		assert.Equal(t, "weatherget", names.ToMistral("weather.get"))
+		assert.Equal(t, "myapp/search", names.ToGenkit("myappsearch"))
+		assert.Equal(t, "weather.get", names.ToGenkit("weatherget"))
+	})
+
+	t.Run("should keep colliding names distinct", func(t *testing.T) {
+		// Given
+		tools := []*ai.ToolDefinition{
+			{Name: "a/b"},
+			{Name: "a.b"},
+			{Name: "ab"},
+		}
+
+		// When
+		names := mapping.NewToolNames(tools)
+
+		// Then
+		assert.Equal(t, "ab", names.ToMistral("a/b"))
+		assert.Equal(t, "ab_2", names.ToMistral("a.b"))
+		assert.Equal(t, "ab_3", names.ToMistral("ab"))
+		assert.Equal(t, "a/b", names.ToGenkit("ab"))
+		assert.Equal(t, "a.b", names.ToGenkit("ab_2"))
+		assert.Equal(t, "ab", names.ToGenkit("ab_3"))
+	})
+
+	t.Run("should use a default name when nothing is left after sanitization", func(t *testing.T) {
+		// Given
+		tools := []*ai.ToolDefinition{
+			{Name: "東京"},
+		}
+
+		// When
+		names := mapping.NewToolNames(tools)
+
+		// Then
+		assert.Equal(t, "tool", names.ToMistral("東京"))
+		assert.Equal(t, "東京", names.ToGenkit("tool"))
+	})
+
+	t.Run("should keep suffixed names within the length limit", func(t *testing.T) {
+		// Given
+		long := strings.Repeat("a", 300)
+		tools := []*ai.ToolDefinition{
+			{Name: long},
+			{Name: long + "/other"},
+		}
+
+		// When
+		names := mapping.NewToolNames(tools)
+
+		// Then
+		assert.Len(t, names.ToMistral(long+"/other"), 256)
+		assert.True(t, strings.HasSuffix(names.ToMistral(long+"/other"), "_2"))
+	})
+
+	t.Run("should handle unknown names", func(t *testing.T) {
+		// Given
+		names := mapping.NewToolNames(nil)
+
+		// When
+		toMistral := names.ToMistral("unknown/tool")
+		toGenkit := names.ToGenkit("unknown")
+
+		// Then
+		assert.Equal(t, "unknowntool", toMistral)
+		assert.Equal(t, "unknown", toGenkit)
+	})
+}
+
+func TestLimitToolRequests(t *testing.T) {
+	t.Run("should keep only the first tool request", func(t *testing.T) {
+		// Given
+		msg := ai.NewModelMessage(
+			ai.NewTextPart("Let me check"),
+			ai.NewToolRequestPart(&ai.ToolRequest{Ref: "ref1", Name: "add"}),
+			ai.NewToolRequestPart(&ai.ToolRequest{Ref: "ref2", Name: "inc"}),
+		)
+
+		// When
+		mapping.LimitToolRequests(msg)
+
+		// Then
+		assert.Equal(t, 2, len(msg.Content))
+		assert.Equal(t, "Let me check", msg.Content[0].Text)
+		assert.Equal(t, "ref1", msg.Content[1].ToolRequest.Ref)
+	})
+}
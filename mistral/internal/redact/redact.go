@@ -0,0 +1,224 @@
+package redact
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Detector finds a kind of sensitive value in a text.
+type Detector struct {
+	// Name is used in the placeholders, e.g. EMAIL for [EMAIL_1].
+	Name string
+
+	// Pattern matches the candidate values.
+	Pattern *regexp.Regexp
+
+	// Valid, when set, filters out the candidates which aren't actual values (e.g. with a wrong checksum).
+	Valid func(string) bool
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	ibanPattern  = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`)
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?\(?\b\d{1,4}\)?(?:[ .-]?\d{2,4}){2,5}\b`)
+
+	placeholderPattern = regexp.MustCompile(`\[[A-Z][A-Z0-9_]*_\d+\]`)
+)
+
+// Email detects email addresses.
+func Email() Detector {
+	return Detector{Name: "EMAIL", Pattern: emailPattern}
+}
+
+// IBAN detects international bank account numbers with a valid checksum.
+func IBAN() Detector {
+	return Detector{Name: "IBAN", Pattern: ibanPattern, Valid: validIBAN}
+}
+
+// Phone detects phone numbers: 9 to 15 digits, optionally separated by spaces, dots, dashes or parentheses.
+func Phone() Detector {
+	return Detector{Name: "PHONE", Pattern: phonePattern, Valid: validPhone}
+}
+
+// Custom detects the values matching the pattern. The name is upper-cased in the placeholders.
+func Custom(name string, pattern *regexp.Regexp) Detector {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+	return Detector{Name: name, Pattern: pattern}
+}
+
+func validIBAN(candidate string) bool {
+	iban := strings.ReplaceAll(candidate, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	// ISO 13616: the country code and check digits are moved to the end, letters are converted to numbers
+	// and the remainder of the division by 97 must be 1.
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+func validPhone(candidate string) bool {
+	digits := 0
+	for _, r := range candidate {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits >= 9 && digits <= 15
+}
+
+// Redactor replaces the sensitive values by placeholders.
+type Redactor struct {
+	detectors []Detector
+}
+
+// New returns a redactor using the detectors, in this order.
+func New(detectors ...Detector) *Redactor {
+	return &Redactor{detectors: detectors}
+}
+
+// NewSession starts a session: within it, the same value always gets the same placeholder,
+// and the placeholders can be restored.
+func (r *Redactor) NewSession() *Session {
+	return &Session{
+		redactor:     r,
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// Session holds the values redacted for a request, to restore them in the response.
+type Session struct {
+	mu           sync.Mutex
+	redactor     *Redactor
+	placeholders map[string]string
+	values       map[string]string
+	counts       map[string]int
+}
+
+// Len returns the number of distinct values redacted in the session.
+func (s *Session) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.values)
+}
+
+// Redact replaces the sensitive values of the text by placeholders.
+func (s *Session) Redact(text string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.redactor.detectors {
+		text = d.Pattern.ReplaceAllStringFunc(text, func(match string) string {
+			if placeholderPattern.MatchString(match) || (d.Valid != nil && !d.Valid(match)) {
+				return match
+			}
+			return s.placeholder(d.Name, match)
+		})
+	}
+	return text
+}
+
+func (s *Session) placeholder(name, value string) string {
+	if p, ok := s.placeholders[value]; ok {
+		return p
+	}
+	s.counts[name]++
+	p := fmt.Sprintf("[%s_%d]", name, s.counts[name])
+	s.placeholders[value] = p
+	s.values[p] = value
+	return p
+}
+
+// Restore replaces the placeholders of the text by the original values.
+// Unknown placeholders are kept.
+func (s *Session) Restore(text string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.values) == 0 {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(p string) string {
+		if v, ok := s.values[p]; ok {
+			return v
+		}
+		return p
+	})
+}
+
+// RedactValue redacts the strings held by a JSON-like value (maps, slices and strings).
+func (s *Session) RedactValue(v any) any {
+	return mapStrings(v, s.Redact)
+}
+
+// RestoreValue restores the strings held by a JSON-like value (maps, slices and strings).
+func (s *Session) RestoreValue(v any) any {
+	return mapStrings(v, s.Restore)
+}
+
+func mapStrings(v any, f func(string) string) any {
+	switch t := v.(type) {
+	case string:
+		return f(t)
+	case map[string]any:
+		res := make(map[string]any, len(t))
+		for k, e := range t {
+			res[k] = mapStrings(e, f)
+		}
+		return res
+	case []any:
+		res := make([]any, len(t))
+		for i, e := range t {
+			res[i] = mapStrings(e, f)
+		}
+		return res
+	default:
+		return v
+	}
+}
+
+// StreamRestorer restores the placeholders of a streamed text, whose placeholders may be split between chunks.
+type StreamRestorer struct {
+	session *Session
+	pending string
+}
+
+// NewStreamRestorer returns a restorer for the texts streamed in the session.
+func (s *Session) NewStreamRestorer() *StreamRestorer {
+	return &StreamRestorer{session: s}
+}
+
+// Write returns the restored text that can be emitted after the chunk.
+// The end of the chunk is held back when it could be the beginning of a placeholder.
+func (r *StreamRestorer) Write(chunk string) string {
+	text := r.pending + chunk
+	r.pending = ""
+	if i := strings.LastIndexByte(text, '['); i != -1 && !strings.Contains(text[i:], "]") && len(text)-i <= 64 {
+		text, r.pending = text[:i], text[i:]
+	}
+	return r.session.Restore(text)
+}
+
+// Flush returns the text held back.
+func (r *StreamRestorer) Flush() string {
+	text := r.pending
+	r.pending = ""
+	return r.session.Restore(text)
+}
@@ -0,0 +1,97 @@
+package redact_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/redact"
+)
+
+func TestRedact(t *testing.T) {
+	r := redact.New(redact.Email(), redact.IBAN(), redact.Phone(),
+		redact.Custom("customer id", regexp.MustCompile(`CUST-\d{6}`)))
+
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"should redact emails", "Write to jane.doe+work@example.co.uk today", "Write to [EMAIL_1] today"},
+		{"should redact international phone numbers", "Call +33 6 12 34 56 78", "Call [PHONE_1]"},
+		{"should redact national phone numbers", "Call 06.12.34.56.78 or (555) 123-4567", "Call [PHONE_1] or [PHONE_2]"},
+		{"should redact IBANs", "Pay FR76 3000 6000 0112 3456 7890 189 now", "Pay [IBAN_1] now"},
+		{"should redact compact IBANs", "IBAN: DE89370400440532013000", "IBAN: [IBAN_1]"},
+		{"should keep IBANs with a wrong checksum", "FR76 3000 6000 0112 3456 7890 188", "FR76 3000 6000 0112 3456 7890 188"},
+		{"should keep short numbers", "Order 1234 for 2024-01-15", "Order 1234 for 2024-01-15"},
+		{"should redact custom patterns", "Customer CUST-123456", "Customer [CUSTOMER_ID_1]"},
+		{"should reuse the placeholder of a value", "a@b.io, c@d.io, a@b.io", "[EMAIL_1], [EMAIL_2], [EMAIL_1]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			s := r.NewSession()
+
+			// When
+			redacted := s.Redact(tc.input)
+
+			// Then
+			assert.Equal(t, tc.expected, redacted)
+			assert.Equal(t, tc.input, s.Restore(redacted))
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	r := redact.New(redact.Email())
+
+	t.Run("should keep unknown placeholders", func(t *testing.T) {
+		// Given
+		s := r.NewSession()
+		s.Redact("jane@example.com")
+
+		// When
+		res := s.Restore("[EMAIL_1] and [EMAIL_2]")
+
+		// Then
+		assert.Equal(t, "jane@example.com and [EMAIL_2]", res)
+	})
+
+	t.Run("should redact and restore the strings of JSON values", func(t *testing.T) {
+		// Given
+		s := r.NewSession()
+		value := map[string]any{
+			"to":    "jane@example.com",
+			"cc":    []any{"john@example.com", 42},
+			"count": 2,
+		}
+
+		// When
+		redacted := s.RedactValue(value)
+		restored := s.RestoreValue(redacted)
+
+		// Then
+		assert.Equal(t, map[string]any{
+			"to":    "[EMAIL_1]",
+			"cc":    []any{"[EMAIL_2]", 42},
+			"count": 2,
+		}, redacted)
+		assert.Equal(t, value, restored)
+	})
+
+	t.Run("should restore placeholders split between streamed chunks", func(t *testing.T) {
+		// Given
+		s := r.NewSession()
+		s.Redact("jane@example.com")
+		restorer := s.NewStreamRestorer()
+
+		// When
+		var out string
+		for _, chunk := range []string{"Sent to [EM", "AIL_", "1]", " [sic"} {
+			out += restorer.Write(chunk)
+		}
+		out += restorer.Flush()
+
+		// Then
+		assert.Equal(t, "Sent to jane@example.com [sic", out)
+	})
+}
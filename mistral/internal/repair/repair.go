@@ -0,0 +1,161 @@
+package repair
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var (
+	ErrInvalidJSON   = errors.New("output is not valid JSON")
+	ErrSchemaNotMet  = errors.New("output does not match the expected schema")
+	ErrInvalidSchema = errors.New("invalid output schema")
+)
+
+// Repair fixes the most common issues of JSON generated by a model:
+// markdown code fences, prose around the JSON value and truncated outputs.
+// The text is returned unchanged when it can't be repaired.
+func Repair(text string) string {
+	trimmed := strings.TrimSpace(stripFences(text))
+	if json.Valid([]byte(trimmed)) {
+		return trimmed
+	}
+
+	start := strings.IndexAny(trimmed, "{[")
+	if start == -1 {
+		return text
+	}
+	candidate := trimmed[start:]
+	if json.Valid([]byte(candidate)) {
+		return candidate
+	}
+
+	if repaired, ok := closeJSON(candidate); ok {
+		return repaired
+	}
+	return text
+}
+
+// Validate checks the text is valid JSON matching the schema.
+func Validate(text string, schema map[string]any) error {
+	if !json.Valid([]byte(text)) {
+		return ErrInvalidJSON
+	}
+	if schema == nil {
+		return nil
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewStringLoader(text))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	violations := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		violations = append(violations, e.String())
+	}
+	return fmt.Errorf("%w: %s", ErrSchemaNotMet, strings.Join(violations, "; "))
+}
+
+func stripFences(text string) string {
+	start := strings.Index(text, "```")
+	if start == -1 {
+		return text
+	}
+	body := text[start+3:]
+	if nl := strings.IndexByte(body, '\n'); nl != -1 && !strings.ContainsAny(body[:nl], "{[") {
+		// Skip the language tag (```json).
+		body = body[nl+1:]
+	}
+	if end := strings.Index(body, "```"); end != -1 {
+		body = body[:end]
+	}
+	return body
+}
+
+// cutPoint is a position where the JSON can be cut and closed with the brackets opened so far.
+type cutPoint struct {
+	pos   int
+	stack string
+}
+
+// closeJSON cuts the trailing text after the first complete value, or closes a truncated value.
+// Incomplete values are dropped, up to the last complete member of the innermost container.
+func closeJSON(text string) (string, bool) {
+	var (
+		stack    []byte
+		inString bool
+		escaped  bool
+		cuts     []cutPoint
+	)
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			stack = append(stack, c)
+			cuts = append(cuts, cutPoint{pos: i + 1, stack: string(stack)})
+		case '}', ']':
+			if len(stack) == 0 {
+				return "", false
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				// Trailing text after a complete value.
+				value := text[:i+1]
+				return value, json.Valid([]byte(value))
+			}
+		case ',':
+			cuts = append(cuts, cutPoint{pos: i, stack: string(stack)})
+		}
+	}
+
+	candidate := text
+	if inString {
+		candidate = strings.TrimSuffix(candidate, "\\") + `"`
+	}
+	candidate = strings.TrimRight(strings.TrimSpace(candidate), ",")
+	if closed := candidate + closers(string(stack)); json.Valid([]byte(closed)) {
+		return closed, true
+	}
+
+	for i := len(cuts) - 1; i >= 0; i-- {
+		closed := text[:cuts[i].pos] + closers(cuts[i].stack)
+		if json.Valid([]byte(closed)) {
+			return closed, true
+		}
+	}
+	return "", false
+}
+
+func closers(stack string) string {
+	var b strings.Builder
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] == '{' {
+			b.WriteByte('}')
+		} else {
+			b.WriteByte(']')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package repair_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/repair"
+)
+
+func TestRepair(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"should keep valid JSON", `{"a": 1}`, `{"a": 1}`},
+		{"should strip code fences", "```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"should strip code fences without language", "```\n[1, 2]\n```", `[1, 2]`},
+		{"should remove leading prose", `Here is the JSON: {"a": 1}`, `{"a": 1}`},
+		{"should remove trailing prose", `{"a": 1} Hope it helps!`, `{"a": 1}`},
+		{"should close truncated objects", `{"a": {"b": [1, 2`, `{"a": {"b": [1, 2]}}`},
+		{"should close truncated strings", `{"a": "hel`, `{"a": "hel"}`},
+		{"should drop a dangling key", `{"a": 1, "b":`, `{"a": 1}`},
+		{"should drop an incomplete literal", `{"a": 1, "b": tr`, `{"a": 1}`},
+		{"should keep an empty container when nothing is complete", `{"a": tr`, `{}`},
+		{"should keep braces in strings", `{"a": "{[", "b": 2`, `{"a": "{[", "b": 2}`},
+		{"should return unrepairable text unchanged", `no JSON here`, `no JSON here`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// When
+			res := repair.Repair(tc.input)
+
+			// Then
+			assert.Equal(t, tc.expected, res)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+			"age":  map[string]any{"type": "integer"},
+		},
+		"required": []any{"name", "age"},
+	}
+
+	t.Run("should accept a valid output", func(t *testing.T) {
+		// When
+		err := repair.Validate(`{"name": "Bob", "age": 42}`, schema)
+
+		// Then
+		assert.NoError(t, err)
+	})
+
+	t.Run("should reject invalid JSON", func(t *testing.T) {
+		// When
+		err := repair.Validate(`{"name": "Bob"`, schema)
+
+		// Then
+		assert.ErrorIs(t, err, repair.ErrInvalidJSON)
+	})
+
+	t.Run("should list the schema violations", func(t *testing.T) {
+		// When
+		err := repair.Validate(`{"name": 12}`, schema)
+
+		// Then
+		assert.ErrorIs(t, err, repair.ErrSchemaNotMet)
+		assert.Contains(t, err.Error(), "age")
+		assert.Contains(t, err.Error(), "name")
+	})
+
+	t.Run("should only check the syntax without schema", func(t *testing.T) {
+		// When
+		err := repair.Validate(`[1, 2]`, nil)
+
+		// Then
+		assert.NoError(t, err)
+	})
+}
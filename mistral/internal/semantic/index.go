@@ -0,0 +1,126 @@
+package semantic
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Index is an in-memory vector index, partitioned by scope. It is safe for concurrent use.
+// Lookups are exhaustive: the index is meant for the few thousands entries of a local cache.
+type Index struct {
+	mu      sync.Mutex
+	size    int
+	count   int
+	scopes  map[string][]*entry
+	now     func() time.Time
+	counter uint64
+}
+
+type entry struct {
+	vector  []float32
+	norm    float64
+	value   any
+	expires time.Time
+	seq     uint64
+}
+
+// NewIndex returns an index holding at most size entries. The oldest entries are evicted first.
+func NewIndex(size int) *Index {
+	return &Index{size: size, scopes: make(map[string][]*entry), now: time.Now}
+}
+
+// Add stores the value of the vector in the scope, for the ttl. Zero means the entry never expires.
+func (idx *Index) Add(scope string, vector []float32, value any, ttl time.Duration) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.counter++
+	e := &entry{vector: vector, norm: norm(vector), value: value, seq: idx.counter}
+	if ttl > 0 {
+		e.expires = idx.now().Add(ttl)
+	}
+	idx.scopes[scope] = append(idx.scopes[scope], e)
+	idx.count++
+	for idx.size > 0 && idx.count > idx.size {
+		idx.evictOldest()
+	}
+}
+
+// Nearest returns the value of the scope whose vector is the most similar to the given one,
+// with their cosine similarity. It returns false when the scope is empty.
+func (idx *Index) Nearest(scope string, vector []float32) (any, float64, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	now := idx.now()
+	vnorm := norm(vector)
+	var best *entry
+	bestSimilarity := math.Inf(-1)
+	entries := idx.scopes[scope][:0]
+	for _, e := range idx.scopes[scope] {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			idx.count--
+			continue
+		}
+		entries = append(entries, e)
+		if s := similarity(vector, vnorm, e); s > bestSimilarity {
+			best, bestSimilarity = e, s
+		}
+	}
+	idx.setScope(scope, entries)
+
+	if best == nil {
+		return nil, 0, false
+	}
+	return best.value, bestSimilarity, true
+}
+
+// Len returns the number of entries of the index, expired ones included until they are looked up.
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.count
+}
+
+func (idx *Index) evictOldest() {
+	var oldestScope string
+	var oldest *entry
+	for scope, entries := range idx.scopes {
+		if len(entries) > 0 && (oldest == nil || entries[0].seq < oldest.seq) {
+			oldestScope, oldest = scope, entries[0]
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	idx.setScope(oldestScope, idx.scopes[oldestScope][1:])
+	idx.count--
+}
+
+func (idx *Index) setScope(scope string, entries []*entry) {
+	if len(entries) == 0 {
+		delete(idx.scopes, scope)
+		return
+	}
+	idx.scopes[scope] = entries
+}
+
+func similarity(vector []float32, vnorm float64, e *entry) float64 {
+	if len(vector) != len(e.vector) || vnorm == 0 || e.norm == 0 {
+		return 0
+	}
+	var dot float64
+	for i := range vector {
+		dot += float64(vector[i]) * float64(e.vector[i])
+	}
+	return dot / (vnorm * e.norm)
+}
+
+func norm(vector []float32) float64 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,68 @@
+package semantic_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/semantic"
+)
+
+func TestIndex(t *testing.T) {
+	t.Run("should return the most similar entry of the scope", func(t *testing.T) {
+		// Given
+		idx := semantic.NewIndex(10)
+		idx.Add("a", []float32{1, 0}, "east", 0)
+		idx.Add("a", []float32{0, 1}, "north", 0)
+		idx.Add("b", []float32{1, 0.1}, "other scope", 0)
+
+		// When
+		value, similarity, ok := idx.Nearest("a", []float32{1, 0.1})
+
+		// Then
+		assert.True(t, ok)
+		assert.Equal(t, "east", value)
+		assert.InDelta(t, 0.995, similarity, 0.001)
+	})
+
+	t.Run("should not find anything in an empty scope", func(t *testing.T) {
+		// Given
+		idx := semantic.NewIndex(10)
+		idx.Add("a", []float32{1, 0}, "east", 0)
+
+		// When
+		_, _, ok := idx.Nearest("b", []float32{1, 0})
+
+		// Then
+		assert.False(t, ok)
+	})
+
+	t.Run("should evict the oldest entries", func(t *testing.T) {
+		// Given
+		idx := semantic.NewIndex(2)
+		idx.Add("a", []float32{1, 0}, "first", 0)
+		idx.Add("b", []float32{1, 0}, "second", 0)
+
+		// When
+		idx.Add("b", []float32{0, 1}, "third", 0)
+
+		// Then
+		assert.Equal(t, 2, idx.Len())
+		_, _, ok := idx.Nearest("a", []float32{1, 0})
+		assert.False(t, ok)
+	})
+
+	t.Run("should skip the expired entries", func(t *testing.T) {
+		// Given
+		idx := semantic.NewIndex(10)
+		idx.Add("a", []float32{1, 0}, "east", time.Millisecond)
+
+		// When
+		time.Sleep(5 * time.Millisecond)
+		_, _, ok := idx.Nearest("a", []float32{1, 0})
+
+		// Then
+		assert.False(t, ok)
+		assert.Zero(t, idx.Len())
+	})
+}
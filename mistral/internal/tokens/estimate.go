@@ -0,0 +1,256 @@
+package tokens
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+	"unicode"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+const (
+	// promptOverhead is the number of control tokens wrapping user and system messages ([INST], [/INST]...).
+	promptOverhead = 2
+
+	// answerOverhead is the number of control tokens ending model messages (</s>).
+	answerOverhead = 1
+
+	// requestOverhead is the number of control tokens at the beginning of each request (<s>).
+	requestOverhead = 1
+
+	// toolsOverhead is the number of control tokens wrapping the tool definitions ([AVAILABLE_TOOLS]...).
+	toolsOverhead = 2
+
+	// toolCallOverhead is the number of control tokens of a tool call or tool result, call ID included.
+	toolCallOverhead = 5
+
+	// imagePatchSize is the size in pixels of the square patches an image is split into, one token each.
+	imagePatchSize = 16
+
+	// maxImageSize is the size in pixels of the longest side of an image after resizing by the API.
+	maxImageSize = 1024
+)
+
+// Tokenizer identifies a family of Mistral tokenizers.
+type Tokenizer int
+
+const (
+	// Tekken is the tiktoken based tokenizer of the recent Mistral models (131k vocabulary).
+	Tekken Tokenizer = iota
+
+	// SentencePiece is the tokenizer of the first Mistral models (32k vocabulary).
+	SentencePiece
+)
+
+// sentencePieceModels are the prefixes of the models using a SentencePiece tokenizer.
+var sentencePieceModels = []string{
+	"open-mistral-7b", "open-mixtral-", "mistral-tiny",
+	"mistral-small-2312", "mistral-small-2402", "mistral-medium-2312",
+	"mistral-large-2402", "mistral-large-2407",
+	"codestral-2405", "codestral-mamba", "mistral-embed",
+}
+
+// ForModel returns the tokenizer family of the model. Unknown models use Tekken.
+func ForModel(model string) Tokenizer {
+	for _, prefix := range sentencePieceModels {
+		if strings.HasPrefix(model, prefix) {
+			return SentencePiece
+		}
+	}
+	return Tekken
+}
+
+// wordRunes is the length of the longest word usually encoded in a single token.
+func (t Tokenizer) wordRunes() int {
+	if t == SentencePiece {
+		return 8
+	}
+	return 10
+}
+
+// runesPerToken is the average length of the word pieces following the first one in long words.
+func (t Tokenizer) runesPerToken() int {
+	if t == SentencePiece {
+		return 4
+	}
+	return 6
+}
+
+// nonLatinTokens is the number of tokens of a single non-latin rune. SentencePiece falls back to bytes more often.
+func (t Tokenizer) nonLatinTokens() int {
+	if t == SentencePiece {
+		return 2
+	}
+	return 1
+}
+
+// Text estimates the number of tokens the tokenizer would produce for the given text.
+// Common words are a single token and longer ones are split in word pieces.
+// Digits and punctuation count as one token each.
+func (t Tokenizer) Text(s string) int {
+	count := 0
+	word := 0
+
+	flush := func() {
+		if word > 0 {
+			count++
+			if extra := word - t.wordRunes(); extra > 0 {
+				count += (extra + t.runesPerToken() - 1) / t.runesPerToken()
+			}
+			word = 0
+		}
+	}
+
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r <= unicode.MaxLatin1 && unicode.IsLetter(r):
+			word++
+		case r > unicode.MaxLatin1:
+			flush()
+			count += t.nonLatinTokens()
+		default:
+			flush()
+			count++
+		}
+	}
+	flush()
+
+	return count
+}
+
+// Message estimates the number of tokens of a single message, control tokens included.
+func (t Tokenizer) Message(msg *ai.Message) int {
+	if msg == nil {
+		return 0
+	}
+	count := promptOverhead
+	if msg.Role == ai.RoleModel {
+		count = answerOverhead
+	}
+	for _, part := range msg.Content {
+		count += t.part(part)
+	}
+	return count
+}
+
+func (t Tokenizer) part(part *ai.Part) int {
+	switch {
+	case part.IsText() || part.IsReasoning():
+		return t.Text(part.Text)
+	case part.IsImage():
+		return Image(part.Text)
+	case part.IsToolRequest():
+		return toolCallOverhead + t.Text(part.ToolRequest.Name) + t.JSON(part.ToolRequest.Input)
+	case part.IsToolResponse():
+		count := toolCallOverhead
+		if s, ok := part.ToolResponse.Output.(string); ok {
+			count += t.Text(s)
+		} else {
+			count += t.JSON(part.ToolResponse.Output)
+		}
+		for _, p := range part.ToolResponse.Content {
+			count += t.part(p)
+		}
+		return count
+	}
+	return 0
+}
+
+// JSON estimates the number of tokens of the JSON encoding of the value.
+func (t Tokenizer) JSON(v any) int {
+	if v == nil {
+		return 0
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return t.Text(string(b))
+}
+
+// Request estimates the number of prompt tokens of a model request:
+// messages, tool definitions and output schema, control tokens included.
+func (t Tokenizer) Request(mr *ai.ModelRequest) int {
+	if mr == nil {
+		return 0
+	}
+	count := requestOverhead
+	for _, msg := range mr.Messages {
+		count += t.Message(msg)
+	}
+	if len(mr.Tools) > 0 {
+		count += toolsOverhead
+		for _, tool := range mr.Tools {
+			count += t.JSON(map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name":        tool.Name,
+					"description": tool.Description,
+					"parameters":  tool.InputSchema,
+				},
+			})
+		}
+	}
+	if mr.Output != nil && mr.Output.Constrained && mr.Output.Schema != nil {
+		count += t.JSON(mr.Output.Schema)
+	}
+	return count
+}
+
+// Image estimates the number of tokens of an image: one token per patch, plus one per row of patches.
+// The size is read from data URLs, other images are assumed to be at the maximum size.
+func Image(url string) int {
+	width, height := maxImageSize, maxImageSize
+	if w, h, ok := imageSize(url); ok {
+		width, height = w, h
+	}
+	if longest := max(width, height); longest > maxImageSize {
+		width = width * maxImageSize / longest
+		height = height * maxImageSize / longest
+	}
+	cols := (width + imagePatchSize - 1) / imagePatchSize
+	rows := (height + imagePatchSize - 1) / imagePatchSize
+	return rows * (cols + 1)
+}
+
+func imageSize(url string) (int, int, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return 0, 0, false
+	}
+	_, data, found := strings.Cut(url, ";base64,")
+	if !found {
+		return 0, 0, false
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return 0, 0, false
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return 0, 0, false
+	}
+	return cfg.Width, cfg.Height, true
+}
+
+// Text estimates the number of tokens of the text with the Tekken tokenizer.
+func Text(s string) int {
+	return Tekken.Text(s)
+}
+
+// Message estimates the number of tokens of a single message with the Tekken tokenizer.
+func Message(msg *ai.Message) int {
+	return Tekken.Message(msg)
+}
+
+// Request estimates the number of prompt tokens of a model request with the Tekken tokenizer.
+func Request(mr *ai.ModelRequest) int {
+	return Tekken.Request(mr)
+}
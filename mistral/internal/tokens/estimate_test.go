@@ -0,0 +1,172 @@
+package tokens_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
+)
+
+func TestText(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{"empty text", "", 0},
+		{"single short word", "Hello", 1},
+		{"words and punctuation", "Hello, world!", 4},
+		{"long word", "internationalization", 3},
+		{"digits", "2025", 4},
+		{"non latin runes", "東京", 2},
+	} {
+		t.Run("should estimate tokens for "+tc.name, func(t *testing.T) {
+			// When
+			got := tokens.Text(tc.input)
+
+			// Then
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestRequest(t *testing.T) {
+	t.Run("should count control tokens for each message", func(t *testing.T) {
+		// Given
+		mr := &ai.ModelRequest{
+			Messages: []*ai.Message{
+				ai.NewSystemTextMessage("Be nice"),
+				ai.NewUserTextMessage("Hello, world!"),
+			},
+		}
+
+		// When
+		got := tokens.Request(mr)
+
+		// Then
+		assert.Equal(t, 1+(2+2)+(2+4), got)
+	})
+
+	t.Run("should return zero for a nil request", func(t *testing.T) {
+		// When
+		got := tokens.Request(nil)
+
+		// Then
+		assert.Equal(t, 0, got)
+	})
+}
+
+func TestForModel(t *testing.T) {
+	for _, tc := range []struct {
+		model    string
+		expected tokens.Tokenizer
+	}{
+		{"mistral-small-latest", tokens.Tekken},
+		{"ministral-8b-latest", tokens.Tekken},
+		{"open-mistral-7b", tokens.SentencePiece},
+		{"open-mixtral-8x22b", tokens.SentencePiece},
+		{"unknown-model", tokens.Tekken},
+	} {
+		t.Run("should return the tokenizer of "+tc.model, func(t *testing.T) {
+			// When
+			got := tokens.ForModel(tc.model)
+
+			// Then
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestImage(t *testing.T) {
+	pngDataURL := func(width, height int) string {
+		var buf bytes.Buffer
+		_ = png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+		return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	}
+
+	t.Run("should count one token per patch and per row", func(t *testing.T) {
+		// When
+		got := tokens.Image(pngDataURL(64, 32))
+
+		// Then
+		assert.Equal(t, 2*(4+1), got)
+	})
+
+	t.Run("should resize large images", func(t *testing.T) {
+		// When
+		got := tokens.Image(pngDataURL(2048, 1024))
+
+		// Then
+		assert.Equal(t, 32*(64+1), got)
+	})
+
+	t.Run("should assume the maximum size for remote images", func(t *testing.T) {
+		// When
+		got := tokens.Image("https://example.com/cat.png")
+
+		// Then
+		assert.Equal(t, 64*(64+1), got)
+	})
+}
+
+func TestRequestOverheads(t *testing.T) {
+	base := func() *ai.ModelRequest {
+		return &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("What's the weather in Paris?")}}
+	}
+
+	t.Run("should count the tool definitions", func(t *testing.T) {
+		// Given
+		mr := base()
+		mr.Tools = []*ai.ToolDefinition{{
+			Name:        "weather",
+			Description: "Get the weather of a city",
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"city": map[string]any{"type": "string"}},
+			},
+		}}
+
+		// When
+		got := tokens.Request(mr)
+
+		// Then
+		assert.Greater(t, got, tokens.Request(base())+20)
+	})
+
+	t.Run("should count the output schema of constrained outputs", func(t *testing.T) {
+		// Given
+		mr := base()
+		mr.Output = &ai.ModelOutputConfig{
+			Format:      ai.OutputFormatJSON,
+			Constrained: true,
+			Schema:      map[string]any{"type": "object"},
+		}
+
+		// When
+		got := tokens.Request(mr)
+
+		// Then
+		assert.Equal(t, tokens.Request(base())+tokens.Text(`{"type":"object"}`), got)
+	})
+
+	t.Run("should count tool calls and tool results", func(t *testing.T) {
+		// Given
+		mr := base()
+		mr.Messages = append(mr.Messages,
+			ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{
+				Name: "weather", Input: map[string]any{"city": "Paris"}})),
+			ai.NewMessage(ai.RoleTool, nil, ai.NewToolResponsePart(&ai.ToolResponse{
+				Name: "weather", Output: "sunny"})))
+
+		// When
+		got := tokens.Request(mr)
+
+		// Then
+		assert.Greater(t, got, tokens.Request(base())+tokens.Text(`{"city":"Paris"}`)+tokens.Text("sunny"))
+	})
+}
@@ -0,0 +1,101 @@
+package tokens_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+var record = flag.Bool("record", false, "record the prompt tokens fixtures from the Mistral API (needs MISTRAL_API_KEY)")
+
+// maxRelativeError is the accepted difference between the estimation and the usage reported by Mistral.
+const maxRelativeError = 0.15
+
+type promptTokensFixture struct {
+	Name         string           `json:"name"`
+	Model        string           `json:"model"`
+	Request      *ai.ModelRequest `json:"request"`
+	PromptTokens int              `json:"promptTokens"`
+}
+
+func TestRequestAccuracy(t *testing.T) {
+	path := filepath.Join("testdata", "prompt_tokens.json")
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var fixtures []*promptTokensFixture
+	require.NoError(t, json.Unmarshal(b, &fixtures))
+
+	if *record {
+		recordFixtures(t, path, fixtures)
+	}
+
+	for _, f := range fixtures {
+		t.Run("should estimate the prompt tokens of "+f.Name, func(t *testing.T) {
+			// When
+			got := tokens.ForModel(f.Model).Request(f.Request)
+
+			// Then
+			relErr := math.Abs(float64(got-f.PromptTokens)) / float64(f.PromptTokens)
+			assert.LessOrEqualf(t, relErr, maxRelativeError,
+				"estimated %d tokens, Mistral reported %d", got, f.PromptTokens)
+		})
+	}
+}
+
+func recordFixtures(t *testing.T, path string, fixtures []*promptTokensFixture) {
+	apiKey := os.Getenv("MISTRAL_API_KEY")
+	require.NotEmpty(t, apiKey, "MISTRAL_API_KEY is required to record the fixtures")
+	// The complete schemas are sent, as the plugin does.
+	transport := &schemasTransport{}
+	client := mistral.New(apiKey, mistral.WithClientTransport(transport))
+
+	for _, f := range fixtures {
+		req, schemas, err := mapping.MapRequestToMistral(f.Model, f.Request, &mistral.CompletionConfig{MaxTokens: 1})
+		require.NoError(t, err)
+		transport.schemas = schemas
+		resp, err := client.ChatCompletion(context.Background(), req)
+		require.NoError(t, err)
+		f.PromptTokens = resp.Usage.PromptTokens
+	}
+
+	b, err := json.MarshalIndent(fixtures, "", "  ")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append(b, '\n'), 0o644))
+}
+
+// schemasTransport replaces the schemas serialized by mistral-client with the complete ones.
+type schemasTransport struct {
+	schemas *mapping.Schemas
+}
+
+func (t *schemasTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || t.schemas == nil {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if body, err = mapping.PatchSchemas(body, t.schemas); err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return http.DefaultTransport.RoundTrip(req)
+}
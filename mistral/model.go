@@ -5,13 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math"
 	"math/rand"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/firebase/genkit/go/core/api"
 	"github.com/thomas-marquis/genkit-mistral/internal"
 	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
 	"github.com/thomas-marquis/mistral-client/mistral"
 
 	"github.com/firebase/genkit/go/ai"
@@ -25,9 +30,38 @@ var (
 	ErrInvalidModelInput = fmt.Errorf("invalid model input")
 )
 
-func defineModel(c mistral.Client, modelInfo *ai.ModelInfo) ai.Model {
+const (
+	// ResponseMetadataOutputAttempts is the response message metadata key holding the number of completions
+	// requested to get a valid structured output, when the output repair is enabled.
+	ResponseMetadataOutputAttempts = "outputAttempts"
+
+	// ResponseMetadataOutputRepaired is the response message metadata key set to true
+	// when the structured output has been repaired locally or by asking the model again.
+	ResponseMetadataOutputRepaired = "outputRepaired"
+)
+
+// modelConfig holds the plugin settings applied to a model.
+type modelConfig struct {
+	requestOpts    []mapping.RequestOption
+	repairAttempts int
+	contextLength  int
+	contextWindow  *ContextWindowPolicy
+	prices         PriceTable
+	costs          *CostTracker
+	budget         *budgetGuard
+	logger         *slog.Logger
+	hooks          hooks
+	cache          *responseCache
+	semanticCache  *semanticCache
+	defaults       *mistral.CompletionConfig
+
+	// parallelToolCalls is the plugin setting of the parallel tool calls, overridden by map configs.
+	parallelToolCalls bool
+}
+
+func defineModel(namespace string, c mistral.Client, modelInfo *ai.ModelInfo, mc modelConfig) ai.Model {
 	return ai.NewModel(
-		api.NewName(providerID, modelInfo.Label),
+		api.NewName(namespace, modelInfo.Label),
 		&ai.ModelOptions{
 			Label:    modelInfo.Label,
 			Stage:    modelInfo.Stage,
@@ -35,38 +69,343 @@ func defineModel(c mistral.Client, modelInfo *ai.ModelInfo) ai.Model {
 			Versions: modelInfo.Versions,
 		},
 		func(ctx context.Context, mr *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			mr, err := withConfigDefaults(mr, mc.defaults)
+			if err != nil {
+				return nil, err
+			}
 			cfg, err := configFromRequest(mr)
 			if err != nil {
 				return nil, err
 			}
+			tok := tokens.ForModel(modelInfo.Label)
 
-			req, err := mapping.MapRequestToMistral(modelInfo.Label, mr, cfg)
-			if err != nil {
-				if errors.Is(err, mapping.ErrNoMessages) {
-					return nil, errors.Join(ErrInvalidModelInput, err)
+			if mc.contextWindow != nil {
+				if mr, err = fitContextWindow(ctx, c, mc, tok, mr, cfg); err != nil {
+					if errors.Is(err, ErrContextWindowExceeded) {
+						return nil, errors.Join(ErrInvalidModelInput, err)
+					}
+					return nil, err
 				}
-				return nil, err
 			}
 
-			response, err := c.ChatCompletion(ctx, req)
+			settle, err := mc.budget.reserveRequest(ctx, modelInfo.Label, mc.prices,
+				tok.Request(mr), mc.budget.outputTokens(cfg.MaxTokens))
 			if err != nil {
-				return nil, fmt.Errorf("failed to get chat completion: %w", err)
+				return nil, err
 			}
 
-			mresp, err := mapping.MapToGenkitResponse(mr, response)
+			generate := func(mr *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+				return generateCompletion(ctx, c, modelInfo, mr, mc, cb)
+			}
+			var resp *ai.ModelResponse
+			if mc.repairAttempts > 0 {
+				resp, err = generateWithRepair(ctx, mr, mc.repairAttempts, cb, generate)
+			} else {
+				resp, err = generate(mr, cb)
+			}
 			if err != nil {
+				settle(0, true)
 				return nil, err
 			}
 
-			return mresp, nil
+			cost, ok := setResponseCost(resp, modelInfo.Label, mc.prices)
+			if ok && mc.costs != nil {
+				mc.costs.Record(ctx, cost)
+			}
+			settle(cost.Total, ok)
+			return resp, nil
 		},
 	)
 }
 
-func defineFakeModel() ai.Model {
+// generateCompletion calls Mistral through the hooks. With a stream callback, the completion is streamed.
+func generateCompletion(
+	ctx context.Context, c mistral.Client, modelInfo *ai.ModelInfo, mr *ai.ModelRequest, mc modelConfig,
+	cb ai.ModelStreamCallback,
+) (*ai.ModelResponse, error) {
+	cfg, err := configFromRequest(mr)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ParallelToolCalls = parallelToolCalls(mr.Config, mc.parallelToolCalls)
+
+	req, schemas, err := mapping.MapRequestToMistral(modelInfo.Label, mr, cfg, mc.requestOpts...)
+	if err != nil {
+		if errors.Is(err, mapping.ErrNoMessages) ||
+			errors.Is(err, mapping.ErrUnknownTool) ||
+			errors.Is(err, mapping.ErrInvalidToolChoice) ||
+			errors.Is(err, mapping.ErrOrphanToolResponse) ||
+			errors.Is(err, mapping.ErrInvalidPrefix) ||
+			errors.Is(err, mapping.ErrInvalidHistory) {
+			return nil, errors.Join(ErrInvalidModelInput, err)
+		}
+		return nil, err
+	}
+
+	// mistral-client can't serialize the complete schemas, so they are sent by the plugin transport.
+	ctx = withSchemas(ctx, schemas)
+
+	if !modelInfo.Supports.ToolChoice {
+		// Models without function calling reject any tool_choice, even one coming from the config.
+		req.ToolChoice = ""
+		schemas.ToolChoice = nil
+	}
+
+	call := &Call{Operation: OperationChat, ModelRequest: mr, ChatRequest: req}
+	if cb != nil {
+		call.Operation = OperationChatStream
+		req.Stream = true
+	}
+
+	bypass := cacheBypassed(ctx, mr.Config)
+	streamed, cached, semanticHit := false, false, false
+	var similarity float64
+	send := func(ctx context.Context, call *Call) error {
+		var err error
+		complete := func() (*mistral.ChatCompletionResponse, error) {
+			if call.Operation != OperationChatStream {
+				return c.ChatCompletion(ctx, call.ChatRequest)
+			}
+			streamed = true
+			streamCB, flush := restoringStream(call, cb)
+			resp, err := streamCompletion(ctx, c, call.ChatRequest, mapping.ResponsePrefix(mr), streamCB)
+			if err != nil {
+				return nil, err
+			}
+			return resp, flush(ctx)
+		}
+		// The exact cache is looked up first, as it doesn't need to embed the prompt.
+		call.ChatResponse, cached, err = mc.cache.chat(ctx, call.ChatRequest, bypass,
+			func() (*mistral.ChatCompletionResponse, error) {
+				resp, similar, hit, err := mc.semanticCache.chat(ctx, call.ChatRequest, bypass, complete)
+				similarity, semanticHit = similar, hit
+				return resp, err
+			})
+		cached = cached || semanticHit
+		if err != nil {
+			return fmt.Errorf("failed to get chat completion: %w", err)
+		}
+		if !cached {
+			mc.logCompletion(ctx, call.ChatRequest, call.ChatResponse)
+		}
+		return nil
+	}
+
+	mapResponse := func(call *Call) error {
+		mresp, err := mapping.MapToGenkitResponse(call.ModelRequest, call.ChatResponse)
+		if err != nil {
+			return err
+		}
+		if len(call.ChatRequest.Tools) > 0 && !call.ChatRequest.ParallelToolCalls {
+			// mistral-client omits parallel_tool_calls when false, so it is enforced here.
+			mapping.LimitToolRequests(mresp.Message)
+		}
+		if cached && mresp.Message != nil {
+			if mresp.Message.Metadata == nil {
+				mresp.Message.Metadata = make(map[string]any)
+			}
+			mresp.Message.Metadata[ResponseMetadataCacheHit] = true
+			if semanticHit {
+				mresp.Message.Metadata[ResponseMetadataCacheSimilarity] = similarity
+			}
+			// Nothing was consumed, so nothing is charged.
+			mresp.Usage = &ai.GenerationUsage{}
+		}
+		call.ModelResponse = mresp
+		return nil
+	}
+
+	if err := mc.hooks.intercept(ctx, call, send, mapResponse); err != nil {
+		return nil, err
+	}
+	if call.Operation == OperationChatStream && !streamed && call.ModelResponse.Message != nil {
+		// The responses given by a hook or the cache are streamed as a single chunk, once restored by the hooks.
+		if err := cb(ctx, &ai.ModelResponseChunk{Role: ai.RoleModel, Content: call.ModelResponse.Message.Content}); err != nil {
+			return nil, err
+		}
+	}
+	return call.ModelResponse, nil
+}
+
+func (mc modelConfig) logCompletion(
+	ctx context.Context, req *mistral.ChatCompletionRequest, resp *mistral.ChatCompletionResponse,
+) {
+	if mc.logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("model", req.Model),
+		slog.String("requestId", resp.Id),
+		slog.Duration("latency", resp.Latency),
+	}
+	if resp.Usage != nil {
+		attrs = append(attrs,
+			slog.Int("inputTokens", resp.Usage.PromptTokens),
+			slog.Int("outputTokens", resp.Usage.CompletionTokens))
+	}
+	mc.logger.LogAttrs(ctx, slog.LevelDebug, "Chat completion", attrs...)
+}
+
+// streamCompletion streams the text of the completion, starting with the prefix, to the callback
+// and returns the response assembled from the chunks.
+func streamCompletion(
+	ctx context.Context, c mistral.Client, req *mistral.ChatCompletionRequest, prefix string, cb ai.ModelStreamCallback,
+) (*mistral.ChatCompletionResponse, error) {
+	chunks, err := c.ChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// Unblocks the client when the stream is abandoned early.
+		go func() {
+			for range chunks {
+			}
+		}()
+	}()
+
+	emit := func(text string) error {
+		return cb(ctx, &ai.ModelResponseChunk{Role: ai.RoleModel, Content: []*ai.Part{ai.NewTextPart(text)}})
+	}
+
+	// Like the unstreamed responses, the streamed text only gets the prefix when it doesn't already start with it,
+	// so the text is held back until it can be compared with the prefix.
+	pending := prefix != ""
+	release := func(text string) error {
+		pending = false
+		if !strings.HasPrefix(text, prefix) {
+			text = prefix + text
+		}
+		return emit(text)
+	}
+
+	resp := &mistral.ChatCompletionResponse{}
+	var text strings.Builder
+	var toolCalls []mistral.ToolCall
+	var finishReason mistral.FinishReason
+	for chunk := range chunks {
+		if chunk.Error != nil {
+			return nil, chunk.Error
+		}
+		if chunk.Id != "" {
+			resp.Id = chunk.Id
+		}
+		if chunk.Model != "" {
+			resp.Model = chunk.Model
+		}
+		resp.Created = chunk.Created
+		resp.Latency = chunk.TotalLatency
+		if chunk.Usage != nil {
+			resp.Usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+		if choice.Delta == nil {
+			continue
+		}
+		toolCalls = append(toolCalls, choice.Delta.ToolCalls...)
+		if choice.Delta.Content() == nil {
+			continue
+		}
+		delta := choice.Delta.Content().String()
+		if delta == "" {
+			continue
+		}
+		text.WriteString(delta)
+		if !pending {
+			if err := emit(delta); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if buffered := text.String(); len(buffered) >= len(prefix) || !strings.HasPrefix(prefix, buffered) {
+			if err := release(buffered); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if pending {
+		if err := release(text.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	resp.Choices = []mistral.ChatCompletionChoice{{
+		FinishReason: finishReason,
+		Message:      mistral.NewAssistantMessageFromString(text.String(), toolCalls...),
+	}}
+	return resp, nil
+}
+
+type fakeMode int
+
+const (
+	fakeModeLorem fakeMode = iota
+	fakeModeEcho
+	fakeModeTemplate
+)
+
+type fakeModelConfig struct {
+	seed     int
+	hasSeed  bool
+	mode     fakeMode
+	template string
+}
+
+// fakeRand is the private random source of the fake model and embedder, seeded with the fake seed when set.
+// Unlike the global math/rand source, it can't be affected by, nor affect, the rest of the program.
+type fakeRand struct {
+	mu      sync.Mutex
+	src     *rand.Rand
+	seed    int
+	hasSeed bool
+}
+
+func newFakeRand(fc fakeModelConfig) *fakeRand {
+	seed := time.Now().UnixNano()
+	if fc.hasSeed {
+		seed = int64(fc.seed)
+	}
+	return &fakeRand{src: rand.New(rand.NewSource(seed)), seed: fc.seed, hasSeed: fc.hasSeed}
+}
+
+// forRequest returns the generator of a request. It is seeded with the request seed if not zero, or else
+// with the fake seed, so that identical requests produce identical outputs.
+// Without any seed, it is seeded from the private source.
+func (r *fakeRand) forRequest(seed int) *rand.Rand {
+	if seed == 0 && r.hasSeed {
+		seed = r.seed
+	}
+	if seed != 0 {
+		return rand.New(rand.NewSource(int64(seed)))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return rand.New(rand.NewSource(r.src.Int63()))
+}
+
+type fakeTemplateData struct {
+	Prompt       string
+	System       string
+	MessageCount int
+}
+
+func defineFakeModel(namespace string, fc fakeModelConfig, rng *fakeRand) ai.Model {
 	modelName := "fake-completion"
+
+	var tmpl *template.Template
+	var tmplErr error
+	if fc.mode == fakeModeTemplate {
+		tmpl, tmplErr = template.New(modelName).Parse(fc.template)
+	}
+
 	return ai.NewModel(
-		api.NewName(providerID, modelName),
+		api.NewName(namespace, modelName),
 		&ai.ModelOptions{
 			Label: strings.ToTitle(modelName),
 			Supports: &ai.ModelSupports{
@@ -87,21 +426,57 @@ func defineFakeModel() ai.Model {
 				return nil, fmt.Errorf("no messages provided in the model request")
 			}
 
-			nbWords := calculateFakeWordCount(cfg.Temperature, cfg.MaxTokens)
-
-			fakeResponse, err := internal.FakeText(nbWords)
+			start := time.Now()
+			text, err := generateFakeText(fc, rng, tmpl, tmplErr, mr, cfg)
 			if err != nil {
-				return nil, fmt.Errorf("failed to generate fake response: %w", err)
+				return nil, err
 			}
 
-			return mapResponseFromText(mr, fakeResponse), nil
+			resp := mapResponseFromText(mr, text)
+			resp.FinishReason = ai.FinishReasonStop
+			resp.LatencyMs = float64(time.Since(start).Milliseconds())
+			setEstimatedUsage(resp)
+
+			return resp, nil
 		},
 	)
 }
 
+func generateFakeText(
+	fc fakeModelConfig, fr *fakeRand, tmpl *template.Template, tmplErr error, mr *ai.ModelRequest, cfg *mistral.CompletionConfig,
+) (string, error) {
+	switch fc.mode {
+	case fakeModeEcho:
+		return textFromMessages(mr.Messages, ai.RoleUser, true), nil
+
+	case fakeModeTemplate:
+		if tmplErr != nil {
+			return "", fmt.Errorf("invalid fake response template: %w", tmplErr)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, fakeTemplateData{
+			Prompt:       textFromMessages(mr.Messages, ai.RoleUser, true),
+			System:       textFromMessages(mr.Messages, ai.RoleSystem, false),
+			MessageCount: len(mr.Messages),
+		}); err != nil {
+			return "", fmt.Errorf("failed to render fake response template: %w", err)
+		}
+		return sb.String(), nil
+	}
+
+	rng := fr.forRequest(cfg.RandomSeed)
+	nbWords := calculateFakeWordCount(rng, cfg.Temperature, cfg.MaxTokens)
+
+	fakeResponse, err := internal.FakeTextFrom(rng, nbWords)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate fake response: %w", err)
+	}
+	return fakeResponse, nil
+}
+
 // calculateFakeWordCount determines the number of words to generate for the fake model response.
 // The calculation is based on the temperature and maxOutputTokens parameters.
-func calculateFakeWordCount(temperature float64, maxOutputTokens int) int {
+func calculateFakeWordCount(rng *rand.Rand, temperature float64, maxOutputTokens int) int {
 	n := maxOutputTokens
 	if n == 0 {
 		n = defaultFakeResponseSize
@@ -126,8 +501,7 @@ func calculateFakeWordCount(temperature float64, maxOutputTokens int) int {
 
 	exponent := math.Pow(10, 2*temperature-1)
 
-	randomFactor := rand.Float64()
-	skewedRandomFactor := math.Pow(randomFactor, exponent)
+	skewedRandomFactor := math.Pow(rng.Float64(), exponent)
 
 	wordCountRange := float64(maxWords - minWords)
 	words := float64(minWords) + wordCountRange*skewedRandomFactor
@@ -135,6 +509,19 @@ func calculateFakeWordCount(temperature float64, maxOutputTokens int) int {
 	return int(math.Round(words))
 }
 
+// parallelToolCalls reports whether the model may answer with several tool calls.
+// A map config setting parallel_tool_calls overrides the plugin setting. The typed config field can't,
+// as its zero value can't be told apart from an explicit false.
+func parallelToolCalls(config any, enabled bool) bool {
+	if m, ok := config.(map[string]any); ok {
+		if v, ok := m["parallel_tool_calls"].(bool); ok {
+			return v
+		}
+	}
+	return enabled
+}
+
+// configFromRequest reads the completion config of the request.
 func configFromRequest(req *ai.ModelRequest) (*mistral.CompletionConfig, error) {
 	var result mistral.CompletionConfig
 
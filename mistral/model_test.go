@@ -1,12 +1,16 @@
 package mistral_test
 
 import (
+	"bytes"
 	"context"
+	"log/slog"
+	"strings"
 	"testing"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/thomas-marquis/genkit-mistral/mistral"
 	"github.com/thomas-marquis/genkit-mistral/mocks"
 	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
@@ -157,3 +161,650 @@ Use the following information to complete your task:
 		assert.ErrorIs(t, err, mistral.ErrInvalidModelInput)
 	})
 }
+
+func TestFakeModel(t *testing.T) {
+	t.Run("should return the same text when the same seed is provided", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled())))
+
+		generate := func() string {
+			res, err := genkit.Generate(ctx, g,
+				ai.WithPrompt("Hello!"),
+				ai.WithConfig(mistralclient.CompletionConfig{Temperature: 0.7, RandomSeed: 42}),
+				ai.WithModelName("mistral/fake-completion"))
+			assert.NoError(t, err)
+			return res.Text()
+		}
+
+		// When
+		first := generate()
+		second := generate()
+
+		// Then
+		assert.NotEmpty(t, first)
+		assert.Equal(t, first, second)
+	})
+
+	t.Run("should return the same text when a plugin seed is set", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g1 := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(), mistral.WithFakeSeed(7))))
+		g2 := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(), mistral.WithFakeSeed(7))))
+
+		// When
+		res1, err1 := genkit.Generate(ctx, g1,
+			ai.WithPrompt("Hello!"),
+			ai.WithModelName("mistral/fake-completion"))
+		res2, err2 := genkit.Generate(ctx, g2,
+			ai.WithPrompt("Hello!"),
+			ai.WithModelName("mistral/fake-completion"))
+
+		// Then
+		assert.NoError(t, err1)
+		assert.NoError(t, err2)
+		assert.Equal(t, res1.Text(), res2.Text())
+	})
+
+	t.Run("should echo the last user message in echo mode", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(), mistral.WithFakeEchoMode())))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithSystem("You are a parrot."),
+			ai.WithMessages(
+				ai.NewUserTextMessage("First question"),
+				ai.NewModelTextMessage("First answer"),
+			),
+			ai.WithPrompt("Repeat after me"),
+			ai.WithModelName("mistral/fake-completion"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Repeat after me", res.Text())
+	})
+
+	t.Run("should render the template in template mode", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(),
+				mistral.WithFakeTemplateMode("[{{.System}}] {{.Prompt}} ({{.MessageCount}})"))))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithSystem("Be nice"),
+			ai.WithPrompt("Hi"),
+			ai.WithModelName("mistral/fake-completion"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "[Be nice] Hi (2)", res.Text())
+	})
+
+	t.Run("should return an error when the template is invalid", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(),
+				mistral.WithFakeTemplateMode("{{.Prompt"))))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hi"),
+			ai.WithModelName("mistral/fake-completion"))
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorContains(t, err, "invalid fake response template")
+	})
+}
+
+func TestFakeModelUsage(t *testing.T) {
+	t.Run("should report estimated token usage", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(
+			mistral.NewPlugin("fake", mistral.WithAPICallsDisabled(), mistral.WithFakeEchoMode())))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithSystem("Be nice"),
+			ai.WithPrompt("Hello, world!"),
+			ai.WithModelName("mistral/fake-completion"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.NotNil(t, res.Usage)
+		assert.Equal(t, 1+(2+2)+(2+4), res.Usage.InputTokens)
+		assert.Equal(t, 1+4, res.Usage.OutputTokens)
+		assert.Equal(t, res.Usage.InputTokens+res.Usage.OutputTokens, res.Usage.TotalTokens)
+		assert.Equal(t, len("Be nice")+len("Hello, world!"), res.Usage.InputCharacters)
+		assert.Equal(t, len("Hello, world!"), res.Usage.OutputCharacters)
+		assert.Equal(t, ai.FinishReasonStop, res.FinishReason)
+	})
+}
+
+func TestGenerateWithToolChoice(t *testing.T) {
+	setupListModelWithFunctionCalling := func(c *mocks.MockClient) {
+		c.EXPECT().
+			ListModels(gomock.Any()).
+			Return([]*mistralclient.BaseModelCard{{
+				Id: "mistral-small-latest",
+				Capabilities: mistralclient.ModelCapabilities{
+					CompletionChat:  true,
+					FunctionCalling: true,
+				},
+			}}, nil).
+			AnyTimes()
+	}
+
+	t.Run("should force the model to call a specific tool", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithFunctionCalling(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, mistralclient.ToolChoiceAny, x.ToolChoice) &&
+						assert.Equal(t, 2, len(x.Tools))
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Sunny")},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		weather := genkit.DefineTool(g, "weather", "get the weather",
+			func(ctx *ai.ToolContext, input string) (string, error) { return "sunny", nil })
+		news := genkit.DefineTool(g, "news", "get the news",
+			func(ctx *ai.ToolContext, input string) (string, error) { return "nothing new", nil })
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What's the weather?"),
+			ai.WithTools(weather, news),
+			ai.WithToolChoice("weather"),
+			ai.WithReturnToolRequests(true),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Sunny", res.Text())
+	})
+
+	t.Run("should not send a tool choice to a model without function calling", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Empty(t, x.ToolChoice)
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello!"),
+			ai.WithConfig(mistralclient.CompletionConfig{ToolChoice: mistralclient.ToolChoiceAny}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello!", res.Text())
+	})
+}
+
+func TestGenerateWithParallelToolCalls(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		opts          []mistral.Option
+		config        any
+		expectedCalls int
+	}{
+		{"should keep all tool calls when no config is provided", nil, nil, 2},
+		{"should keep all tool calls when parallel tool calls are enabled",
+			nil, mistralclient.CompletionConfig{ParallelToolCalls: true}, 2},
+		{"should keep all tool calls with a typed config not setting parallel tool calls",
+			nil, mistralclient.CompletionConfig{Temperature: 0.7}, 2},
+		{"should keep the first tool call when parallel tool calls are disabled",
+			nil, map[string]any{"parallel_tool_calls": false}, 1},
+		{"should keep the first tool call with a typed config when the plugin disables parallel tool calls",
+			[]mistral.Option{mistral.WithParallelToolCalls(false)}, mistralclient.CompletionConfig{Temperature: 0.7}, 1},
+		{"should keep all tool calls when a map config enables the parallel tool calls disabled by the plugin",
+			[]mistral.Option{mistral.WithParallelToolCalls(false)}, map[string]any{"parallel_tool_calls": true}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Given
+			ctrl := gomock.NewController(t)
+			mockClient := mocks.NewMockClient(ctrl)
+
+			mockClient.EXPECT().
+				ListModels(gomock.Any()).
+				Return([]*mistralclient.BaseModelCard{{
+					Id: "mistral-small-latest",
+					Capabilities: mistralclient.ModelCapabilities{
+						CompletionChat:  true,
+						FunctionCalling: true,
+					},
+				}}, nil).
+				AnyTimes()
+
+			mockClient.EXPECT().
+				ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(&mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{
+							Message: mistralclient.NewAssistantMessageFromString("",
+								mistralclient.NewToolCall("abcdef123", 0, "news", mistralclient.JsonMap{}),
+								mistralclient.NewToolCall("abcdef456", 1, "news", mistralclient.JsonMap{})),
+							FinishReason: mistralclient.FinishReasonToolCalls,
+						},
+					},
+				}, nil)
+
+			p := mistral.NewPlugin("fake", append([]mistral.Option{mistral.WithClient(mockClient)}, tc.opts...)...)
+
+			ctx := context.Background()
+			g := genkit.Init(ctx, genkit.WithPlugins(p))
+			news := genkit.DefineTool(g, "news", "get the news",
+				func(ctx *ai.ToolContext, input any) (string, error) { return "nothing new", nil })
+
+			// When
+			res, err := genkit.Generate(ctx, g,
+				ai.WithPrompt("Any news?"),
+				ai.WithTools(news),
+				ai.WithConfig(tc.config),
+				ai.WithReturnToolRequests(true),
+				ai.WithModelName("mistral/mistral-small-latest"))
+
+			// Then
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCalls, len(res.ToolRequests()))
+		})
+	}
+}
+
+func TestGenerateWithOutputMode(t *testing.T) {
+	type greeting struct {
+		Greeting string `json:"greeting"`
+	}
+
+	t.Run("should send the output schema by default", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, mistralclient.ResponseFormatJsonSchema, x.ResponseFormat.Type)
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString(`{"greeting": "Hello!"}`)},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, _, err := genkit.GenerateData[greeting](ctx, g,
+			ai.WithPrompt("Say hello!"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello!", res.Greeting)
+	})
+
+	t.Run("should use json object format and describe the schema in the prompt", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					last := x.Messages[len(x.Messages)-1].Content().String()
+					return assert.Equal(t, mistralclient.ResponseFormatJsonObject, x.ResponseFormat.Type) &&
+						assert.Nil(t, x.ResponseFormat.JsonSchema) &&
+						assert.Contains(t, last, "conform to the following schema") &&
+						assert.Equal(t, 1, strings.Count(last, "Output should be in JSON format"))
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString(`{"greeting": "Hello!"}`)},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithOutputMode("mistral-small-latest", mistral.OutputModeJSONObject))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, _, err := genkit.GenerateData[greeting](ctx, g,
+			ai.WithPrompt("Say hello!"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Hello!", res.Greeting)
+	})
+}
+
+func TestGenerateWithPrefix(t *testing.T) {
+	t.Run("should send the prefix and return the full text", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					last, ok := x.Messages[len(x.Messages)-1].(*mistralclient.AssistantMessage)
+					return assert.True(t, ok) && assert.True(t, last.Prefix)
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString(" leaves fall")},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(ai.NewUserTextMessage("Write a haiku"), mistral.NewPrefixMessage("Autumn")),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Autumn leaves fall", res.Text())
+	})
+
+	t.Run("should stream the prefix only when the streamed text doesn't start with it", func(t *testing.T) {
+		cases := map[string][]string{
+			"continuation": {" leaves", " fall"},
+			"echo":         {"Aut", "umn leaves", " fall"},
+		}
+		for name, deltas := range cases {
+			t.Run(name, func(t *testing.T) {
+				// Given
+				ctrl := gomock.NewController(t)
+				mockClient := mocks.NewMockClient(ctrl)
+
+				setupListModelWithChatCompletion(mockClient)
+
+				chunks := make(chan *mistralclient.CompletionChunk, len(deltas))
+				for _, delta := range deltas {
+					chunks <- &mistralclient.CompletionChunk{
+						Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString(delta)}},
+					}
+				}
+				close(chunks)
+				mockClient.EXPECT().
+					ChatCompletionStream(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+					Return(chunks, nil)
+
+				p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+				ctx := context.Background()
+				g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+				// When
+				var streamed string
+				res, err := genkit.Generate(ctx, g,
+					ai.WithMessages(ai.NewUserTextMessage("Write a haiku"), mistral.NewPrefixMessage("Autumn")),
+					ai.WithModelName("mistral/mistral-small-latest"),
+					ai.WithStreaming(func(_ context.Context, chunk *ai.ModelResponseChunk) error {
+						streamed += chunk.Text()
+						return nil
+					}))
+
+				// Then
+				assert.NoError(t, err)
+				assert.Equal(t, "Autumn leaves fall", streamed)
+				assert.Equal(t, "Autumn leaves fall", res.Text())
+			})
+		}
+	})
+
+	t.Run("should continue a conversation started with a prefix", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		gomock.InOrder(
+			mockClient.EXPECT().
+				ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(&mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{Message: mistralclient.NewAssistantMessageFromString(" leaves fall")},
+					},
+				}, nil),
+			mockClient.EXPECT().
+				ChatCompletion(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+						return assert.Len(t, x.Messages, 3) &&
+							assert.Equal(t, "Autumn leaves fall", x.Messages[1].Content().String()) &&
+							assert.False(t, x.Messages[1].(*mistralclient.AssistantMessage).Prefix)
+					}),
+				).
+				Return(&mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{Message: mistralclient.NewAssistantMessageFromString("Winter snow")},
+					},
+				}, nil),
+		)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		first, err := genkit.Generate(ctx, g,
+			ai.WithMessages(ai.NewUserTextMessage("Write a haiku"), mistral.NewPrefixMessage("Autumn")),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		require.NoError(t, err)
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(append(first.History(), ai.NewUserTextMessage("Another one"))...),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Winter snow", res.Text())
+	})
+
+	t.Run("should return an invalid input error when the prefix is not the last message", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(mistral.NewPrefixMessage("Autumn"), ai.NewUserTextMessage("Write a haiku")),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorIs(t, err, mistral.ErrInvalidModelInput)
+	})
+}
+
+func TestGenerateWithHistoryPolicy(t *testing.T) {
+	messages := []*ai.Message{
+		ai.NewUserTextMessage("Hello!"),
+		ai.NewSystemTextMessage("You are a helpful assistant."),
+	}
+
+	t.Run("should reject an invalid history by default", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(messages...),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorIs(t, err, mistral.ErrInvalidModelInput)
+		assert.ErrorContains(t, err, "system messages must be at the beginning of the conversation")
+	})
+
+	t.Run("should normalize the history with the configured policy", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, 2, len(x.Messages)) &&
+						assert.Equal(t, mistralclient.RoleSystem, x.Messages[0].Role())
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Hi!")},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithHistoryPolicy(mistral.HistoryPolicy{System: mistral.SystemMessagesHoist}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(messages...),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, "Hi!", res.Text())
+	})
+}
+
+func TestGenerateWithLogger(t *testing.T) {
+	t.Run("should log the completions with the given logger", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		mockClient.EXPECT().
+			ListModels(gomock.Any()).
+			Return([]*mistralclient.BaseModelCard{{
+				Id:           "mistral-small-latest",
+				Capabilities: mistralclient.ModelCapabilities{CompletionChat: true, Vision: true},
+			}}, nil)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.Any(), gomock.Any()).
+			Return(&mistralclient.ChatCompletionResponse{
+				Id: "resp-42",
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Hi!")},
+				},
+				Usage: &mistralclient.UsageInfo{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+			}, nil)
+
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithLogger(logger))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithMessages(ai.NewUserMessage(
+				ai.NewTextPart("What is it?"),
+				ai.NewMediaPart("video/mp4", "data:video/mp4;base64,AAAA"),
+			)),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		logs := buf.String()
+		assert.Contains(t, logs, `level=WARN msg="Unsupported media type, part ignored" model=mistral-small-latest message=0 part=1 contentType=video/mp4`)
+		assert.Contains(t, logs, `level=DEBUG msg="Chat completion" model=mistral-small-latest requestId=resp-42`)
+		assert.Contains(t, logs, "inputTokens=5 outputTokens=2")
+	})
+}
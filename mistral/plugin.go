@@ -2,12 +2,17 @@ package mistral
 
 import (
 	"context"
+	"log/slog"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/core/api"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
 	"github.com/thomas-marquis/mistral-client/mistral"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const providerID = "mistral"
@@ -18,17 +23,92 @@ type Plugin struct {
 	APIKey string
 	Client mistral.Client
 
+	namespace        string
+	clientOpts       []mistral.Option
+	endpoint         *EndpointProfile
 	apiCallsDisabled bool
+	fake             fakeModelConfig
+	outputModes      map[string]OutputMode
+	repairAttempts   int
+	noParallelCalls  bool
+	history          HistoryPolicy
+	contextWindow    *ContextWindowPolicy
+	prices           PriceTable
+	costs            *CostTracker
+	budgetPolicy     *BudgetPolicy
+	tracerProvider   trace.TracerProvider
+	meterProvider    metric.MeterProvider
+	logger           *slog.Logger
+	hooks            hooks
+	redaction        *RedactionPolicy
+	cachePolicy      *CachePolicy
+	semanticPolicy   *SemanticCachePolicy
+	virtualModels    []virtualModel
+	aliases          []modelAlias
+	defaultConfigs   []modelDefaultConfig
+	customClient     bool
+
+	// configDefaults are the default configs, by model ID.
+	defaultConfigByID map[string]mistral.CompletionConfig
 }
 
+// OutputMode is the way a model is asked to produce JSON outputs.
+type OutputMode string
+
+const (
+	// OutputModeJSONSchema sends the output schema with the json_schema response format.
+	// The output is natively constrained by Mistral. This is the default.
+	OutputModeJSONSchema OutputMode = "json_schema"
+
+	// OutputModeJSONObject uses the json_object response format: the output is valid JSON,
+	// and the expected schema is described in the prompt.
+	// Use it for the models which don't support structured outputs.
+	OutputModeJSONObject OutputMode = "json_object"
+)
+
+// SystemMessagePolicy defines how system messages found after the beginning of the conversation are handled.
+type SystemMessagePolicy = mapping.SystemMessagePolicy
+
+const (
+	// SystemMessagesStrict rejects system messages found after the beginning of the conversation. This is the default.
+	SystemMessagesStrict = mapping.SystemMessagesStrict
+
+	// SystemMessagesHoist moves every system message to the beginning of the conversation, keeping their order.
+	SystemMessagesHoist = mapping.SystemMessagesHoist
+
+	// SystemMessagesMerge merges every system message into a single one, at the beginning of the conversation.
+	SystemMessagesMerge = mapping.SystemMessagesMerge
+)
+
+// HistoryPolicy defines how the message history is normalized before being sent to Mistral:
+// the way misplaced system messages are handled (System), the merge of consecutive user and model messages
+// (MergeConsecutive) and the removal of the tool responses not answering a tool request (DropOrphanToolResponses).
+// After normalization, a history breaking Mistral ordering rules is rejected with ErrInvalidModelInput.
+type HistoryPolicy = mapping.HistoryPolicy
+
 type Option func(plugin *Plugin)
 
 // WithClient sets the client to use for the plugin.
 // For exotic use case, you can define your own mistral.Client implementation with this option.
-// Don't use it with WithClientOptions.
+// The JSON schemas of the outputs and tools are then limited to the keywords of mistral.PropertyDefinition,
+// and a forced tool is sent with the "any" tool choice: Init logs a warning about it.
+// It replaces the options set by a previous WithClientOptions.
 func WithClient(client mistral.Client) Option {
 	return func(p *Plugin) {
 		p.Client = client
+		p.customClient = client != nil
+		p.clientOpts = nil
+	}
+}
+
+// WithNamespace sets the namespace of the plugin, "mistral" by default.
+// The models and embedders are named <namespace>/<model>, so that several plugins, e.g. with different API keys
+// or clients, can be registered in the same Genkit instance as long as their namespaces differ.
+func WithNamespace(namespace string) Option {
+	return func(p *Plugin) {
+		if namespace != "" {
+			p.namespace = namespace
+		}
 	}
 }
 
@@ -43,39 +123,283 @@ func WithAPICallsDisabled() Option {
 	}
 }
 
-// WithClientOptions sets the options to use for the client (timeout, custom transport...).
-// Don't use it with WithClient.
+// WithFakeSeed sets the default seed used by the fake completion model and the fake embedder.
+// Completion requests with a non-zero CompletionConfig.RandomSeed override it.
+// With a seed, identical requests always produce identical fake outputs.
+func WithFakeSeed(seed int) Option {
+	return func(p *Plugin) {
+		p.fake.seed = seed
+		p.fake.hasSeed = true
+	}
+}
+
+// WithFakeEchoMode makes the fake completion model answer with the text of the last user message.
+func WithFakeEchoMode() Option {
+	return func(p *Plugin) {
+		p.fake.mode = fakeModeEcho
+	}
+}
+
+// WithFakeTemplateMode makes the fake completion model answer with the given text/template rendered
+// against the request. Available fields are:
+//   - .Prompt: the text of the last user message
+//   - .System: the text of the system messages
+//   - .MessageCount: the number of messages in the request
+//
+// Example: "You said: {{.Prompt}}"
+func WithFakeTemplateMode(tmpl string) Option {
+	return func(p *Plugin) {
+		p.fake.mode = fakeModeTemplate
+		p.fake.template = tmpl
+	}
+}
+
+// WithOutputMode sets the way the given model is asked to produce JSON outputs.
+// Models without an explicit mode use OutputModeJSONSchema.
+func WithOutputMode(model string, mode OutputMode) Option {
+	return func(p *Plugin) {
+		if p.outputModes == nil {
+			p.outputModes = make(map[string]OutputMode)
+		}
+		p.outputModes[model] = mode
+	}
+}
+
+// WithOutputRepair validates the structured outputs against the requested schema.
+// Invalid outputs are first repaired locally (code fences, surrounding prose, truncated JSON).
+// If the output is still invalid, the model is asked again with the validation errors,
+// up to maxAttempts times. The number of completions is reported in the response message metadata
+// (see ResponseMetadataOutputAttempts).
+func WithOutputRepair(maxAttempts int) Option {
+	return func(p *Plugin) {
+		p.repairAttempts = maxAttempts
+	}
+}
+
+// WithParallelToolCalls enables or disables the parallel tool calls of every model. They are enabled by default,
+// as on Mistral. When disabled, only the first tool call of each answer is kept.
+// A map config with a "parallel_tool_calls" key overrides this setting for its request.
+func WithParallelToolCalls(enabled bool) Option {
+	return func(p *Plugin) {
+		p.noParallelCalls = !enabled
+	}
+}
+
+// WithHistoryPolicy sets the normalization applied to the message history of every request.
+// By default, the history is only validated.
+func WithHistoryPolicy(policy HistoryPolicy) Option {
+	return func(p *Plugin) {
+		p.history = policy
+	}
+}
+
+// WithContextWindow shortens the requests exceeding the model context window, according to the policy.
+// Without this option, such requests are sent as-is and rejected by Mistral.
+func WithContextWindow(policy ContextWindowPolicy) Option {
+	return func(p *Plugin) {
+		p.contextWindow = &policy
+	}
+}
+
+// WithPrices overrides the prices of the embedded price table (see DefaultPrices).
+// The costs computed from them are attached to the responses (see ResponseCost and EmbeddingCost).
+func WithPrices(prices PriceTable) Option {
+	return func(p *Plugin) {
+		for model, price := range prices {
+			p.prices[model] = price
+		}
+	}
+}
+
+// WithCostTracker records the cost of every request in the tracker.
+func WithCostTracker(tracker *CostTracker) Option {
+	return func(p *Plugin) {
+		p.costs = tracker
+	}
+}
+
+// WithBudget enforces the budgets of the policy: requests which would exceed one of them
+// fail with ErrBudgetExceeded before calling Mistral.
+func WithBudget(policy BudgetPolicy) Option {
+	return func(p *Plugin) {
+		p.budgetPolicy = &policy
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer used to create a span for each call to Mistral.
+// The global provider is used by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(p *Plugin) {
+		p.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider sets the provider of the meter used to record the token usage, latency and error metrics.
+// The global provider is used by default.
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(p *Plugin) {
+		p.meterProvider = mp
+	}
+}
+
+// WithLogger sets the logger used by the plugin: completions at debug level,
+// lossy request conversions and budget warnings at warn level.
+// Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Plugin) {
+		if logger != nil {
+			p.logger = logger
+		}
+	}
+}
+
+// WithHooks registers hooks intercepting the calls of the models and embedders to Mistral.
+// Hooks registered first see the requests first and the responses last (see Hook).
+func WithHooks(hooks ...Hook) Option {
+	return func(p *Plugin) {
+		p.hooks = append(p.hooks, hooks...)
+	}
+}
+
+// WithRedaction replaces the sensitive values of the requests by placeholders before sending them to Mistral,
+// in the messages, the tool calls and the tool outputs, as well as in the embedded texts.
+// The original values are restored in the responses and the tool requests.
+// The hooks only see the redacted requests and responses.
+func WithRedaction(policy RedactionPolicy) Option {
+	return func(p *Plugin) {
+		p.redaction = &policy
+	}
+}
+
+// WithCache caches the chat completions and the embeddings, according to the policy.
+// The cache is keyed by the requests sent to Mistral, once modified by the hooks and redacted.
+// Cached responses are marked with ResponseMetadataCacheHit or EmbeddingMetadataCacheHit.
+// Use WithoutCache, ConfigNoCache or EmbeddingOptions.NoCache to bypass it for a request.
+func WithCache(policy CachePolicy) Option {
+	return func(p *Plugin) {
+		p.cachePolicy = &policy
+	}
+}
+
+// WithSemanticCache answers the chat completions whose final user message is similar to a cached one
+// with its response, according to the policy (see SemanticCachePolicy).
+// Responses from the semantic cache are marked with ResponseMetadataCacheHit and ResponseMetadataCacheSimilarity.
+// It is bypassed like the exact cache (see WithCache), which is looked up first when both are enabled.
+func WithSemanticCache(policy SemanticCachePolicy) Option {
+	return func(p *Plugin) {
+		p.semanticPolicy = &policy
+	}
+}
+
+// WithVirtualModel defines a model named <namespace>/<name>, sending the requests to the models of the policy in order:
+// the first model supporting the request is called, and the next ones when it fails (see FallbackPolicy).
+// The model which answered is reported in the response message metadata (see ResponseMetadataModel).
+// Unknown models are ignored.
+func WithVirtualModel(name string, policy FallbackPolicy) Option {
+	return func(p *Plugin) {
+		p.virtualModels = append(p.virtualModels, virtualModel{name: name, policy: policy})
+	}
+}
+
+// WithModelAlias defines a model named <namespace>/<alias>, forwarding the requests to the target:
+// a Mistral model, a virtual model (see WithVirtualModel) or a previously defined alias.
+// The target is reported in the response message metadata (see ResponseMetadataModel).
+// Aliases to unknown models are ignored.
+func WithModelAlias(alias, target string) Option {
+	return func(p *Plugin) {
+		p.aliases = append(p.aliases, modelAlias{alias: alias, target: target})
+	}
+}
+
+// WithDefaultConfig sets the default config of a model, virtual model or alias.
+// The non-zero fields of a request typed config, or the keys of a request map config, override the defaults.
+// The defaults of an alias override the defaults of its target.
+// The model may be named with or without the plugin namespace. Only the first default config of a model is kept.
+func WithDefaultConfig(model string, config mistral.CompletionConfig) Option {
+	return func(p *Plugin) {
+		p.defaultConfigs = append(p.defaultConfigs, modelDefaultConfig{model: model, config: config})
+	}
+}
+
+type modelDefaultConfig struct {
+	model  string
+	config mistral.CompletionConfig
+}
+
+// WithClientOptions sets the options to use for the client (timeout, rate limiter...).
+// A transport set with mistral.WithClientTransport replaces the plugin one, which sends the complete
+// JSON schemas of the outputs and tools: set it in EndpointProfile.Transport instead.
+// The client is built by NewPlugin, once the other options (e.g. WithEndpoint) are known,
+// and replaces the one set by a previous WithClient.
 func WithClientOptions(opts ...mistral.Option) Option {
 	return func(p *Plugin) {
-		p.Client = mistral.New(p.APIKey, opts...)
+		p.Client = nil
+		p.clientOpts = append(p.clientOpts, opts...)
+	}
+}
+
+// WithEndpoint targets a Mistral-compatible endpoint other than La Plateforme, described by the profile
+// (see EndpointProfile). The models keep their Genkit names, translated from and to the endpoint ones.
+// With WithClient, the base URL, auth scheme and transport of the profile are ignored.
+func WithEndpoint(profile EndpointProfile) Option {
+	return func(p *Plugin) {
+		p.endpoint = &profile
 	}
 }
 
 func NewPlugin(apiKey string, opts ...Option) *Plugin {
 	p := &Plugin{
-		APIKey: apiKey,
+		APIKey:    apiKey,
+		namespace: providerID,
+		prices:    DefaultPrices(),
+		logger:    slog.New(slog.DiscardHandler),
 	}
 
 	for _, opt := range opts {
 		opt(p)
 	}
+	if len(p.clientOpts) > 0 {
+		p.Client = p.newClient()
+		p.customClient = false
+	}
+	p.resolveDefaultConfigs()
 
 	return p
 }
 
+// newClient builds the Mistral client of the plugin, targeting its endpoint.
+func (p *Plugin) newClient() mistral.Client {
+	var opts []mistral.Option
+	var transport http.RoundTripper
+	if p.endpoint != nil {
+		opts = p.endpoint.clientOptions()
+		transport = p.endpoint.transport(p.APIKey)
+	}
+	opts = append(opts, mistral.WithClientTransport(&schemaTransport{base: transport, logger: p.logger}))
+	return mistral.New(p.APIKey, append(opts, p.clientOpts...)...)
+}
+
 func (p *Plugin) Name() string {
-	return providerID
+	return p.namespace
 }
 
 func (p *Plugin) Init(ctx context.Context) []api.Action {
 	if p.Client == nil {
-		p.Client = mistral.New(p.APIKey)
+		p.Client = p.newClient()
+	} else if p.customClient {
+		p.logger.WarnContext(ctx, "Custom client: the complete JSON schemas and the specific tool choices are not sent, "+
+			"only the keywords of mistral.PropertyDefinition and the \"any\" tool choice are")
+	}
+	client := NewInstrumentedClient(p.Client, p.tracerProvider, p.meterProvider)
+	if p.endpoint != nil {
+		// The spans and metrics report the models requested to the endpoint.
+		client = newEndpointClient(client, *p.endpoint)
 	}
 
 	var err error
 	var mistralModels []*mistral.BaseModelCard
 	if !p.apiCallsDisabled {
-		mistralModels, err = p.Client.ListModels(ctx)
+		mistralModels, err = client.ListModels(ctx)
 		if err != nil {
 			panic(err)
 		}
@@ -84,28 +408,140 @@ func (p *Plugin) Init(ctx context.Context) []api.Action {
 	p.Lock()
 	defer p.Unlock()
 
+	var budget *budgetGuard
+	if p.budgetPolicy != nil {
+		budget = newBudgetGuard(*p.budgetPolicy, p.APIKey, p.logger)
+	}
+
+	var cache *responseCache
+	if p.cachePolicy != nil {
+		cache = newResponseCache(*p.cachePolicy, p.logger)
+	}
+	interceptors := p.hooks
+	if p.redaction != nil {
+		interceptors = append(hooks{redactionHook{redactor: p.redaction.redactor()}}, p.hooks...)
+	}
+
+	var semantic *semanticCache
+	if p.semanticPolicy != nil {
+		semantic = newSemanticCache(client, *p.semanticPolicy, p.prices, p.costs, budget, interceptors, p.logger)
+	}
+
 	var actions []api.Action
 	modelSet := make(map[string]struct{})
+	defined := make(map[string]definedModel)
 
 	for _, card := range mistralModels {
 		if _, ok := modelSet[card.Id]; !ok {
 			if !card.IsEmbedding() {
-				model := defineModel(p.Client, mapCardToModelInfo(card))
+				info := mapCardToModelInfo(card)
+				mc := modelConfig{
+					repairAttempts:    p.repairAttempts,
+					contextLength:     card.MaxContextLength,
+					contextWindow:     p.contextWindow,
+					prices:            p.prices,
+					costs:             p.costs,
+					budget:            budget,
+					logger:            p.logger,
+					hooks:             interceptors,
+					cache:             cache,
+					semanticCache:     semantic,
+					defaults:          p.defaultConfig(card.Id),
+					parallelToolCalls: !p.noParallelCalls,
+					requestOpts: []mapping.RequestOption{
+						mapping.WithHistoryPolicy(p.history),
+						mapping.WithLogger(p.logger),
+					},
+				}
+				if p.outputModes[card.Id] == OutputModeJSONObject {
+					// Genkit then describes the expected output in the prompt.
+					info.Supports.Constrained = ai.ConstrainedSupportNone
+					mc.requestOpts = append(mc.requestOpts, mapping.WithJSONObjectOutput())
+				}
+				model := defineModel(p.namespace, client, info, mc)
 				actions = append(actions, model.(api.Action))
+				defined[card.Id] = definedModel{name: card.Id, model: model, info: info}
 			} else {
-				actions = append(actions, defineEmbedder(p.Client, card.Id).(api.Action))
+				actions = append(actions, defineEmbedder(p.namespace, client, card.Id, embedderConfig{
+					prices: p.prices,
+					costs:  p.costs,
+					budget: budget,
+					hooks:  interceptors,
+					cache:  cache,
+					logger: p.logger,
+				}).(api.Action))
 			}
 			modelSet[card.Id] = struct{}{}
 		}
 	}
-	actions = append(actions, defineFakeModel().(api.Action))
-	actions = append(actions, defineFakeEmbedder().(api.Action))
+	for _, vm := range p.virtualModels {
+		if _, ok := modelSet[vm.name]; ok {
+			p.logger.WarnContext(ctx, "Virtual model named after an existing model, ignored", slog.String("model", vm.name))
+			continue
+		}
+		var chain []definedModel
+		for _, name := range vm.policy.Models {
+			c, ok := defined[modelID(p.namespace, name)]
+			if !ok {
+				p.logger.WarnContext(ctx, "Unknown model in fallback chain, ignored",
+					slog.String("virtualModel", vm.name), slog.String("model", name))
+				continue
+			}
+			chain = append(chain, c)
+		}
+		model := defineVirtualModel(p.namespace, vm.name, vm.policy, chain, p.defaultConfig(vm.name), p.logger)
+		actions = append(actions, model.model.(api.Action))
+		defined[vm.name] = model
+		modelSet[vm.name] = struct{}{}
+	}
+	for _, a := range p.aliases {
+		if _, ok := modelSet[a.alias]; ok {
+			p.logger.WarnContext(ctx, "Alias named after an existing model, ignored", slog.String("model", a.alias))
+			continue
+		}
+		target, ok := defined[modelID(p.namespace, a.target)]
+		if !ok {
+			p.logger.WarnContext(ctx, "Alias to an unknown model, ignored",
+				slog.String("alias", a.alias), slog.String("model", a.target))
+			continue
+		}
+		model := defineAlias(p.namespace, a.alias, target, p.defaultConfig(a.alias))
+		actions = append(actions, model.model.(api.Action))
+		defined[a.alias] = model
+		modelSet[a.alias] = struct{}{}
+	}
+	fakeRng := newFakeRand(p.fake)
+	actions = append(actions, defineFakeModel(p.namespace, p.fake, fakeRng).(api.Action))
+	actions = append(actions, defineFakeEmbedder(p.namespace, fakeRng, p.logger).(api.Action))
 
 	return actions
 }
 
 var _ api.Plugin = &Plugin{}
 
+// resolveDefaultConfigs keys the default configs by model ID, once the namespace is known.
+// The configs registered again for a model, possibly under another name, are ignored.
+func (p *Plugin) resolveDefaultConfigs() {
+	p.defaultConfigByID = make(map[string]mistral.CompletionConfig, len(p.defaultConfigs))
+	for _, d := range p.defaultConfigs {
+		id := modelID(p.namespace, d.model)
+		if _, ok := p.defaultConfigByID[id]; ok {
+			p.logger.Warn("Duplicate default config, ignored", slog.String("model", d.model))
+			continue
+		}
+		p.defaultConfigByID[id] = d.config
+	}
+}
+
+// defaultConfig returns the default config of the model, if any.
+func (p *Plugin) defaultConfig(model string) *mistral.CompletionConfig {
+	config, ok := p.defaultConfigByID[model]
+	if !ok {
+		return nil
+	}
+	return &config
+}
+
 func mapCardToModelInfo(card *mistral.BaseModelCard) *ai.ModelInfo {
 	stage := ai.ModelStageStable
 	if !card.Deprecation.IsZero() && card.Deprecation.After(time.Now()) {
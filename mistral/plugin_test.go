@@ -0,0 +1,167 @@
+package mistral_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPluginNamespace(t *testing.T) {
+	newClient := func(ctrl *gomock.Controller, answer string) *mocks.MockClient {
+		mockClient := mocks.NewMockClient(ctrl)
+		mockClient.EXPECT().
+			ListModels(gomock.Any()).
+			Return([]*mistralclient.BaseModelCard{
+				{Id: "mistral-small-latest", Capabilities: mistralclient.ModelCapabilities{CompletionChat: true}},
+				{Id: "mistral-embed"},
+			}, nil)
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString(answer)},
+				},
+			}, nil).
+			AnyTimes()
+		return mockClient
+	}
+
+	t.Run("should name the plugin after the default namespace", func(t *testing.T) {
+		// When
+		p := mistral.NewPlugin("fake")
+
+		// Then
+		assert.Equal(t, "mistral", p.Name())
+	})
+
+	t.Run("should register several plugins with different namespaces and clients", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		production := mistral.NewPlugin("fake",
+			mistral.WithClient(newClient(ctrl, "Hello from production")))
+		sandbox := mistral.NewPlugin("fake",
+			mistral.WithNamespace("mistral-sandbox"),
+			mistral.WithClient(newClient(ctrl, "Hello from sandbox")))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(production, sandbox))
+
+		// When
+		productionRes, productionErr := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		sandboxRes, sandboxErr := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("mistral-sandbox/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, productionErr)
+		require.NoError(t, sandboxErr)
+		assert.Equal(t, "Hello from production", productionRes.Text())
+		assert.Equal(t, "Hello from sandbox", sandboxRes.Text())
+		assert.Equal(t, "mistral-sandbox", sandbox.Name())
+		assert.NotNil(t, genkit.LookupEmbedder(g, "mistral-sandbox/mistral-embed"))
+		assert.NotNil(t, genkit.LookupModel(g, "mistral-sandbox/fake-completion"))
+		assert.NotNil(t, genkit.LookupEmbedder(g, "mistral-sandbox/fake-embed"))
+	})
+
+	t.Run("should resolve the options model names within the namespace", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t, "mistral-small-latest", x.Model) &&
+						assert.Equal(t, 0.3, x.Temperature)
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithNamespace("sandbox"),
+			mistral.WithClient(mockClient),
+			mistral.WithVirtualModel("resilient-chat", mistral.FallbackPolicy{
+				Models: []string{"sandbox/mistral-small-latest"},
+			}),
+			mistral.WithModelAlias("chat-default", "sandbox/resilient-chat"),
+			mistral.WithDefaultConfig("sandbox/chat-default", mistralclient.CompletionConfig{Temperature: 0.3}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello"),
+			ai.WithModelName("sandbox/chat-default"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "mistral-small-latest", res.Message.Metadata[mistral.ResponseMetadataModel])
+		assert.Nil(t, genkit.LookupModel(g, "mistral/chat-default"))
+	})
+}
+
+func TestPluginClientOptions(t *testing.T) {
+	t.Run("should build the client when creating the plugin", func(t *testing.T) {
+		// When
+		p := mistral.NewPlugin("fake", mistral.WithClientOptions(mistralclient.WithBaseApiUrl("http://localhost:8000")))
+
+		// Then
+		assert.NotNil(t, p.Client)
+	})
+
+	t.Run("should keep the last of the client and the client options", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		// When
+		withOptions := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithClientOptions(mistralclient.WithBaseApiUrl("http://localhost:8000")))
+		withClient := mistral.NewPlugin("fake",
+			mistral.WithClientOptions(mistralclient.WithBaseApiUrl("http://localhost:8000")),
+			mistral.WithClient(mockClient))
+
+		// Then
+		assert.NotNil(t, withOptions.Client)
+		assert.NotSame(t, mockClient, withOptions.Client)
+		assert.Same(t, mockClient, withClient.Client)
+	})
+
+	t.Run("should warn that a custom client doesn't send the complete schemas", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithAPICallsDisabled(),
+			mistral.WithLogger(logger))
+
+		// When
+		genkit.Init(context.Background(), genkit.WithPlugins(p))
+
+		// Then
+		assert.Contains(t, buf.String(), `level=WARN msg="Custom client: the complete JSON schemas`)
+	})
+}
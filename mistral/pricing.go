@@ -0,0 +1,127 @@
+package mistral
+
+import (
+	_ "embed"
+	"encoding/json"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+const (
+	// ResponseCustomCost is the key of the Cost in the custom data of model responses.
+	ResponseCustomCost = "cost"
+
+	// EmbeddingMetadataCost is the embedding metadata key holding the Cost of the embedding.
+	EmbeddingMetadataCost = "cost"
+
+	tokensPerPriceUnit = 1_000_000
+)
+
+//go:embed pricing.json
+var embeddedPrices []byte
+
+// Price is the public price of a model, in US dollars.
+type Price struct {
+	// Input is the price of one million input tokens.
+	Input float64 `json:"input,omitempty"`
+
+	// Output is the price of one million output tokens.
+	Output float64 `json:"output,omitempty"`
+}
+
+// PriceTable holds the prices per model. The keys are model names or model families:
+// "mistral-small" gives the price of "mistral-small-latest" and "mistral-small-2506".
+type PriceTable map[string]Price
+
+// DefaultPrices returns a copy of the price table embedded in the package.
+// Prices change over time, override them with WithPrices when needed.
+func DefaultPrices() PriceTable {
+	var prices PriceTable
+	if err := json.Unmarshal(embeddedPrices, &prices); err != nil {
+		panic(err)
+	}
+	return prices
+}
+
+// Lookup returns the price of the model: the exact model name first, then the longest matching family.
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+	var (
+		best  string
+		price Price
+	)
+	for family, p := range t {
+		if strings.HasPrefix(model, family+"-") && len(family) > len(best) {
+			best, price = family, p
+		}
+	}
+	return price, best != ""
+}
+
+// Cost is the price paid for a request, in US dollars.
+type Cost struct {
+	Model        string  `json:"model"`
+	InputTokens  int     `json:"inputTokens"`
+	OutputTokens int     `json:"outputTokens"`
+	Input        float64 `json:"input"`
+	Output       float64 `json:"output"`
+	Total        float64 `json:"total"`
+}
+
+// Cost computes the cost of the given usage.
+func (p Price) Cost(model string, inputTokens, outputTokens int) Cost {
+	c := Cost{
+		Model:        model,
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		Input:        float64(inputTokens) * p.Input / tokensPerPriceUnit,
+		Output:       float64(outputTokens) * p.Output / tokensPerPriceUnit,
+	}
+	c.Total = c.Input + c.Output
+	return c
+}
+
+// ResponseCost returns the cost attached to a model response, if any.
+func ResponseCost(resp *ai.ModelResponse) (Cost, bool) {
+	if resp == nil {
+		return Cost{}, false
+	}
+	custom, ok := resp.Custom.(map[string]any)
+	if !ok {
+		return Cost{}, false
+	}
+	c, ok := custom[ResponseCustomCost].(Cost)
+	return c, ok
+}
+
+// EmbeddingCost returns the cost attached to an embedding, if any.
+func EmbeddingCost(emb *ai.Embedding) (Cost, bool) {
+	if emb == nil {
+		return Cost{}, false
+	}
+	c, ok := emb.Metadata[EmbeddingMetadataCost].(Cost)
+	return c, ok
+}
+
+// setResponseCost attaches the cost of the response usage to its custom data.
+func setResponseCost(resp *ai.ModelResponse, model string, prices PriceTable) (Cost, bool) {
+	if resp == nil || resp.Usage == nil {
+		return Cost{}, false
+	}
+	price, ok := prices.Lookup(model)
+	if !ok {
+		return Cost{}, false
+	}
+	c := price.Cost(model, resp.Usage.InputTokens, resp.Usage.OutputTokens)
+
+	custom, ok := resp.Custom.(map[string]any)
+	if !ok {
+		custom = make(map[string]any)
+	}
+	custom[ResponseCustomCost] = c
+	resp.Custom = custom
+	return c, true
+}
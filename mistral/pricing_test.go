@@ -0,0 +1,212 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPriceTable(t *testing.T) {
+	prices := mistral.PriceTable{
+		"mistral-small":         {Input: 0.1, Output: 0.3},
+		"mistral-small-special": {Input: 1, Output: 1},
+		"mistral-embed":         {Input: 0.1},
+	}
+
+	for _, tc := range []struct {
+		model    string
+		expected mistral.Price
+		found    bool
+	}{
+		{"mistral-small", mistral.Price{Input: 0.1, Output: 0.3}, true},
+		{"mistral-small-latest", mistral.Price{Input: 0.1, Output: 0.3}, true},
+		{"mistral-small-special-2506", mistral.Price{Input: 1, Output: 1}, true},
+		{"mistral-smaller", mistral.Price{}, false},
+		{"unknown", mistral.Price{}, false},
+	} {
+		t.Run("should look up the price of "+tc.model, func(t *testing.T) {
+			// When
+			price, found := prices.Lookup(tc.model)
+
+			// Then
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.expected, price)
+		})
+	}
+
+	t.Run("should embed default prices", func(t *testing.T) {
+		// When
+		_, found := mistral.DefaultPrices().Lookup("mistral-large-latest")
+
+		// Then
+		assert.True(t, found)
+	})
+
+	t.Run("should compute the cost of a usage", func(t *testing.T) {
+		// When
+		cost := mistral.Price{Input: 2, Output: 6}.Cost("mistral-large-latest", 1_000, 500)
+
+		// Then
+		assert.InDelta(t, 0.002, cost.Input, 1e-9)
+		assert.InDelta(t, 0.003, cost.Output, 1e-9)
+		assert.InDelta(t, 0.005, cost.Total, 1e-9)
+	})
+}
+
+func TestGenerateCost(t *testing.T) {
+	t.Run("should attach the cost to the response and record it", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("Hello!")},
+				},
+				Usage: &mistralclient.UsageInfo{PromptTokens: 1_000_000, CompletionTokens: 500_000, TotalTokens: 1_500_000},
+			}, nil).
+			Times(2)
+
+		tracker := mistral.NewCostTracker()
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithPrices(mistral.PriceTable{"mistral-small": {Input: 1, Output: 2}}),
+			mistral.WithCostTracker(tracker))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		flow := genkit.DefineFlow(g, "greet", func(ctx context.Context, _ any) (string, error) {
+			res, err := genkit.Generate(ctx, g,
+				ai.WithPrompt("Hello!"),
+				ai.WithModelName("mistral/mistral-small-latest"))
+			if err != nil {
+				return "", err
+			}
+			return res.Text(), nil
+		})
+
+		// When
+		res, err := genkit.Generate(mistral.WithTenant(ctx, "acme"), g,
+			ai.WithPrompt("Hello!"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		_, flowErr := flow.Run(ctx, nil)
+
+		// Then
+		assert.NoError(t, err)
+		assert.NoError(t, flowErr)
+		cost, ok := mistral.ResponseCost(res)
+		assert.True(t, ok)
+		assert.Equal(t, "mistral-small-latest", cost.Model)
+		assert.InDelta(t, 2.0, cost.Total, 1e-9)
+
+		assert.InDelta(t, 4.0, tracker.Total(mistral.CostFilter{}), 1e-9)
+		assert.Equal(t, map[string]float64{"": 2, "greet": 2}, tracker.ByFlow(mistral.CostFilter{}))
+		assert.Equal(t, map[string]float64{"": 2, "acme": 2}, tracker.ByTenant(mistral.CostFilter{}))
+		assert.InDelta(t, 2.0, tracker.Total(mistral.CostFilter{Tenant: "acme"}), 1e-9)
+		assert.Equal(t, 0.0, tracker.Total(mistral.CostFilter{Since: time.Now().Add(time.Hour)}))
+		assert.Len(t, tracker.ByWindow(mistral.CostFilter{}, 24*time.Hour), 1)
+	})
+
+	t.Run("should not attach a cost to models without price", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(mistral.NewPlugin("fake", mistral.WithAPICallsDisabled())))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Hello!"),
+			ai.WithModelName("mistral/fake-completion"))
+
+		// Then
+		assert.NoError(t, err)
+		_, ok := mistral.ResponseCost(res)
+		assert.False(t, ok)
+	})
+}
+
+func TestEmbedCost(t *testing.T) {
+	t.Run("should split the cost between the embeddings", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithEmbedding(mockClient)
+
+		mockClient.EXPECT().
+			Embeddings(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(&mistralclient.EmbeddingResponse{
+				Data: []mistralclient.EmbeddingData{
+					{Embedding: []float32{1, 2}},
+					{Embedding: []float32{3, 4}},
+				},
+				Usage: mistralclient.UsageInfo{PromptTokens: 3_000_000, TotalTokens: 3_000_000},
+			}, nil)
+
+		tracker := mistral.NewCostTracker()
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithPrices(mistral.PriceTable{"mistral-embed": {Input: 1}}),
+			mistral.WithCostTracker(tracker))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("one", nil), ai.DocumentFromText("one two", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+
+		// Then
+		assert.NoError(t, err)
+		first, ok := mistral.EmbeddingCost(res.Embeddings[0])
+		assert.True(t, ok)
+		second, _ := mistral.EmbeddingCost(res.Embeddings[1])
+		assert.InDelta(t, 1.0, first.Total, 1e-9)
+		assert.InDelta(t, 2.0, second.Total, 1e-9)
+		assert.InDelta(t, 3.0, tracker.Total(mistral.CostFilter{Model: "mistral-embed"}), 1e-9)
+	})
+}
+
+func TestCostTracker(t *testing.T) {
+	t.Run("should keep the last entries only", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		tracker := mistral.NewCostTracker(mistral.WithMaxCostEntries(2))
+
+		// When
+		tracker.Record(ctx, mistral.Cost{Model: "mistral-small-latest", Total: 1})
+		tracker.Record(ctx, mistral.Cost{Model: "mistral-medium-latest", Total: 2})
+		tracker.Record(ctx, mistral.Cost{Model: "mistral-large-latest", Total: 3})
+
+		// Then
+		assert.Len(t, tracker.Entries(mistral.CostFilter{}), 2)
+		assert.Equal(t, map[string]float64{"mistral-medium-latest": 2, "mistral-large-latest": 3},
+			tracker.ByModel(mistral.CostFilter{}))
+	})
+
+	t.Run("should drop the entries older than the retention", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		tracker := mistral.NewCostTracker(mistral.WithCostRetention(50 * time.Millisecond))
+		tracker.Record(ctx, mistral.Cost{Model: "mistral-small-latest", Total: 1})
+		time.Sleep(100 * time.Millisecond)
+
+		// When
+		tracker.Record(ctx, mistral.Cost{Model: "mistral-large-latest", Total: 3})
+
+		// Then
+		assert.Equal(t, map[string]float64{"mistral-large-latest": 3}, tracker.ByModel(mistral.CostFilter{}))
+	})
+}
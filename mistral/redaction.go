@@ -0,0 +1,191 @@
+package mistral
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/redact"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+// RedactionPolicy defines the sensitive values replaced by placeholders before the requests are sent to Mistral.
+// The placeholders are reversible: the original values are restored in the responses and the tool requests.
+type RedactionPolicy struct {
+	// Emails redacts the email addresses, as [EMAIL_1], [EMAIL_2]...
+	Emails bool
+
+	// PhoneNumbers redacts the phone numbers (9 to 15 digits, optionally separated), as [PHONE_1]...
+	PhoneNumbers bool
+
+	// IBANs redacts the bank account numbers with a valid checksum, as [IBAN_1]...
+	IBANs bool
+
+	// Patterns redacts the values matching custom patterns, after the built-in ones.
+	Patterns []RedactionPattern
+}
+
+// RedactionPattern is a custom kind of sensitive value.
+type RedactionPattern struct {
+	// Name is used in the placeholders: "customer id" gives [CUSTOMER_ID_1].
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// DefaultRedactionPolicy redacts the emails, phone numbers and IBANs.
+func DefaultRedactionPolicy() RedactionPolicy {
+	return RedactionPolicy{Emails: true, PhoneNumbers: true, IBANs: true}
+}
+
+func (p RedactionPolicy) redactor() *redact.Redactor {
+	var detectors []redact.Detector
+	if p.Emails {
+		detectors = append(detectors, redact.Email())
+	}
+	// IBANs are detected before the phone numbers, which could match their digits.
+	if p.IBANs {
+		detectors = append(detectors, redact.IBAN())
+	}
+	if p.PhoneNumbers {
+		detectors = append(detectors, redact.Phone())
+	}
+	for _, pattern := range p.Patterns {
+		detectors = append(detectors, redact.Custom(pattern.Name, pattern.Pattern))
+	}
+	return redact.New(detectors...)
+}
+
+// redactionHook redacts the outgoing requests and restores the responses.
+// It is registered before the user hooks, so they only see redacted requests and responses.
+type redactionHook struct {
+	redactor *redact.Redactor
+}
+
+var _ Hook = redactionHook{}
+
+func (h redactionHook) BeforeRequest(_ context.Context, call *Call) error {
+	s := h.redactor.NewSession()
+	call.redaction = s
+
+	if req := call.ChatRequest; req != nil {
+		for _, msg := range req.Messages {
+			redactMessage(s, msg)
+		}
+	}
+	if req := call.EmbeddingRequest; req != nil {
+		for i, input := range req.Input {
+			req.Input[i] = s.Redact(input)
+		}
+	}
+	return nil
+}
+
+func (h redactionHook) AfterResponse(_ context.Context, call *Call) error {
+	s := call.redaction
+	if s == nil || s.Len() == 0 {
+		return nil
+	}
+
+	if resp := call.ChatResponse; resp != nil {
+		for _, choice := range resp.Choices {
+			if choice.Message != nil {
+				restoreMessage(s, choice.Message)
+			}
+		}
+	}
+	if resp := call.ModelResponse; resp != nil && resp.Message != nil {
+		for _, part := range resp.Message.Content {
+			switch {
+			case part.IsText() || part.IsReasoning():
+				part.Text = s.Restore(part.Text)
+			case part.IsToolRequest():
+				part.ToolRequest.Input = restoreInput(s, part.ToolRequest.Input)
+			}
+		}
+	}
+	return nil
+}
+
+func (h redactionHook) OnError(_ context.Context, _ *Call, err error) error {
+	return err
+}
+
+func redactMessage(s *redact.Session, msg mistral.ChatMessage) {
+	var base *mistral.BaseMessage
+	switch m := msg.(type) {
+	case *mistral.SystemMessage:
+		base = &m.BaseMessage
+	case *mistral.UserMessage:
+		base = &m.BaseMessage
+	case *mistral.ToolMessage:
+		base = &m.BaseMessage
+	case *mistral.AssistantMessage:
+		base = &m.BaseMessage
+		for i, tc := range m.ToolCalls {
+			if tc.Function.Arguments == nil {
+				continue
+			}
+			m.ToolCalls[i].Function.Arguments = mistral.JsonMap(
+				s.RedactValue(map[string]any(tc.Function.Arguments)).(map[string]any))
+		}
+	default:
+		return
+	}
+	base.MessageContent = mapContent(base.MessageContent, s.Redact)
+}
+
+func restoreMessage(s *redact.Session, msg *mistral.AssistantMessage) {
+	msg.MessageContent = mapContent(msg.MessageContent, s.Restore)
+	for i, tc := range msg.ToolCalls {
+		if tc.Function.Arguments == nil {
+			continue
+		}
+		msg.ToolCalls[i].Function.Arguments = mistral.JsonMap(
+			s.RestoreValue(map[string]any(tc.Function.Arguments)).(map[string]any))
+	}
+}
+
+// mapContent applies f to the text of the content.
+func mapContent(content mistral.Content, f func(string) string) mistral.Content {
+	switch c := content.(type) {
+	case mistral.ContentString:
+		return mistral.ContentString(f(string(c)))
+	case mistral.ContentChunks:
+		for _, chunk := range c {
+			if text, ok := chunk.(*mistral.TextChunk); ok {
+				text.Text = f(text.Text)
+			}
+		}
+	}
+	return content
+}
+
+func restoreInput(s *redact.Session, input any) any {
+	if m, ok := input.(mistral.JsonMap); ok {
+		input = map[string]any(m)
+	}
+	return s.RestoreValue(input)
+}
+
+// restoringStream restores the placeholders of the streamed chunks, if any.
+// The returned flush function emits the text held back at the end of the stream.
+func restoringStream(call *Call, cb ai.ModelStreamCallback) (ai.ModelStreamCallback, func(context.Context) error) {
+	if call.redaction == nil {
+		return cb, func(context.Context) error { return nil }
+	}
+
+	r := call.redaction.NewStreamRestorer()
+	emit := func(ctx context.Context, text string) error {
+		if text == "" {
+			return nil
+		}
+		return cb(ctx, &ai.ModelResponseChunk{Role: ai.RoleModel, Content: []*ai.Part{ai.NewTextPart(text)}})
+	}
+	restoring := func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+		return emit(ctx, r.Write(chunk.Text()))
+	}
+	flush := func(ctx context.Context) error {
+		return emit(ctx, r.Flush())
+	}
+	return restoring, flush
+}
@@ -0,0 +1,213 @@
+package mistral_test
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGenerateWithRedaction(t *testing.T) {
+	t.Run("should redact the request and restore the response", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		mockClient.EXPECT().
+			ListModels(gomock.Any()).
+			Return([]*mistralclient.BaseModelCard{{
+				Id:           "mistral-small-latest",
+				Capabilities: mistralclient.ModelCapabilities{CompletionChat: true, FunctionCalling: true},
+			}}, nil)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					return assert.Equal(t,
+						"Send my IBAN [IBAN_1] to [EMAIL_1], customer [CUSTOMER_1]",
+						x.Messages[0].Content().String())
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{{
+					Message: mistralclient.NewAssistantMessageFromString("Sending [IBAN_1] to [EMAIL_1].",
+						mistralclient.NewToolCall("call1", 0, "sendMail", mistralclient.JsonMap{"to": "[EMAIL_1]"})),
+				}},
+			}, nil)
+
+		var hookSaw string
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithRedaction(mistral.RedactionPolicy{
+				Emails: true,
+				IBANs:  true,
+				Patterns: []mistral.RedactionPattern{
+					{Name: "customer", Pattern: regexp.MustCompile(`C-\d{4}`)},
+				},
+			}),
+			mistral.WithHooks(mistral.HookFuncs{
+				After: func(_ context.Context, call *mistral.Call) error {
+					hookSaw = call.ModelResponse.Text()
+					return nil
+				},
+			}))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+		sendMail := genkit.DefineTool(g, "sendMail", "Sends a mail",
+			func(ctx *ai.ToolContext, input struct {
+				To string `json:"to"`
+			}) (string, error) {
+				return "sent", nil
+			})
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Send my IBAN FR76 3000 6000 0112 3456 7890 189 to jane@example.com, customer C-1234"),
+			ai.WithTools(sendMail),
+			ai.WithReturnToolRequests(true),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Sending FR76 3000 6000 0112 3456 7890 189 to jane@example.com.", res.Text())
+		require.Len(t, res.ToolRequests(), 1)
+		assert.Equal(t, map[string]any{"to": "jane@example.com"}, res.ToolRequests()[0].Input)
+		assert.Equal(t, "Sending [IBAN_1] to [EMAIL_1].", hookSaw)
+	})
+
+	t.Run("should redact the tool calls and outputs of the history", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+					args, _ := json.Marshal(x.Messages[1].(*mistralclient.AssistantMessage).ToolCalls[0].Function.Arguments)
+					return assert.Contains(t, string(args), `"phone":"[PHONE_1]"`) &&
+						assert.Equal(t, `{"owner":"[EMAIL_1]"}`, x.Messages[2].Content().String())
+				}),
+			).
+			Return(&mistralclient.ChatCompletionResponse{
+				Choices: []mistralclient.ChatCompletionChoice{
+					{Message: mistralclient.NewAssistantMessageFromString("[PHONE_1] belongs to [EMAIL_1]")},
+				},
+			}, nil)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithRedaction(mistral.DefaultRedactionPolicy()))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithMessages(
+				ai.NewUserTextMessage("Who owns this number?"),
+				ai.NewModelMessage(ai.NewToolRequestPart(&ai.ToolRequest{
+					Name: "lookup", Ref: "call1", Input: map[string]any{"phone": "+33 6 12 34 56 78"},
+				})),
+				ai.NewMessage(ai.RoleTool, nil, ai.NewToolResponsePart(&ai.ToolResponse{
+					Name: "lookup", Ref: "call1", Output: map[string]any{"owner": "jane@example.com"},
+				})),
+			),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "+33 6 12 34 56 78 belongs to jane@example.com", res.Text())
+	})
+
+	t.Run("should restore the streamed chunks", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		chunks := make(chan *mistralclient.CompletionChunk, 2)
+		chunks <- &mistralclient.CompletionChunk{
+			Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString("Hi [EMA")}},
+		}
+		chunks <- &mistralclient.CompletionChunk{
+			Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString("IL_1]!")}},
+		}
+		close(chunks)
+
+		mockClient.EXPECT().
+			ChatCompletionStream(gomock.Any(), gomock.Any()).
+			Return((<-chan *mistralclient.CompletionChunk)(chunks), nil)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithRedaction(mistral.DefaultRedactionPolicy()))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		var streamed string
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("I am jane@example.com"),
+			ai.WithModelName("mistral/mistral-small-latest"),
+			ai.WithStreaming(func(_ context.Context, chunk *ai.ModelResponseChunk) error {
+				streamed += chunk.Text()
+				return nil
+			}))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, "Hi jane@example.com!", streamed)
+		assert.Equal(t, "Hi jane@example.com!", res.Text())
+	})
+}
+
+func TestEmbedWithRedaction(t *testing.T) {
+	t.Run("should redact the embedded texts", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithEmbedding(mockClient)
+
+		mockClient.EXPECT().
+			Embeddings(
+				gomock.AssignableToTypeOf(ctxType),
+				gomock.Eq(&mistralclient.EmbeddingRequest{Model: "mistral-embed", Input: []string{"Contact: [EMAIL_1]"}}),
+			).
+			Return(&mistralclient.EmbeddingResponse{
+				Data: []mistralclient.EmbeddingData{{Embedding: []float32{1, 2, 3}}},
+			}, nil)
+
+		p := mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithRedaction(mistral.DefaultRedactionPolicy()))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("Contact: jane@example.com", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Len(t, res.Embeddings, 1)
+	})
+}
@@ -0,0 +1,124 @@
+package mistral
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/repair"
+)
+
+const repairInstructions = "Your previous answer is not valid: %s.\n" +
+	"Answer again with only the corrected JSON, without any explanation."
+
+// generateWithRepair validates the structured output of the response, repairs it locally when possible
+// and asks the model again with the validation errors otherwise, up to maxAttempts times.
+// The last response is returned as-is if it is still invalid, so the caller gets Genkit's parsing error.
+// As an invalid output can't be taken back once streamed, the chunks are discarded and the final output
+// is streamed as a single chunk.
+func generateWithRepair(
+	ctx context.Context, mr *ai.ModelRequest, maxAttempts int, cb ai.ModelStreamCallback,
+	generate func(*ai.ModelRequest, ai.ModelStreamCallback) (*ai.ModelResponse, error),
+) (*ai.ModelResponse, error) {
+	if mr.Output == nil || mr.Output.Format != ai.OutputFormatJSON {
+		return generate(mr, cb)
+	}
+
+	resp, err := repairOutput(mr, maxAttempts, cb != nil, generate)
+	if err != nil {
+		return nil, err
+	}
+	if cb != nil && resp.Message != nil {
+		if err := cb(ctx, &ai.ModelResponseChunk{Role: ai.RoleModel, Content: resp.Message.Content}); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func repairOutput(
+	mr *ai.ModelRequest, maxAttempts int, stream bool,
+	generate func(*ai.ModelRequest, ai.ModelStreamCallback) (*ai.ModelResponse, error),
+) (*ai.ModelResponse, error) {
+	var discard ai.ModelStreamCallback
+	if stream {
+		discard = func(context.Context, *ai.ModelResponseChunk) error { return nil }
+	}
+
+	var usage *ai.GenerationUsage
+	current := mr
+	for attempt := 1; ; attempt++ {
+		resp, err := generate(current, discard)
+		if err != nil {
+			return nil, err
+		}
+		usage = addUsage(usage, resp.Usage)
+
+		if resp.Message == nil || len(resp.ToolRequests()) > 0 {
+			return resp, nil
+		}
+
+		text := resp.Text()
+		fixed := repair.Repair(text)
+		verr := repair.Validate(fixed, mr.Output.Schema)
+		if verr == nil || attempt > maxAttempts {
+			if verr == nil && fixed != text {
+				replaceText(resp.Message, fixed)
+			}
+			if resp.Message.Metadata == nil {
+				resp.Message.Metadata = make(map[string]any)
+			}
+			resp.Message.Metadata[ResponseMetadataOutputAttempts] = attempt
+			resp.Message.Metadata[ResponseMetadataOutputRepaired] = verr == nil && (attempt > 1 || fixed != text)
+			if attempt > 1 {
+				resp.Request = mr
+				resp.Usage = usage
+			}
+			return resp, nil
+		}
+
+		history := current.Messages
+		if mapping.ResponsePrefix(current) != "" {
+			// The response already holds the prefix, which must stay the last message.
+			history = history[:len(history)-1]
+		}
+		next := *current
+		next.Messages = append(append([]*ai.Message{}, history...),
+			resp.Message,
+			ai.NewUserTextMessage(fmt.Sprintf(repairInstructions, verr.Error())))
+		current = &next
+	}
+}
+
+// replaceText replaces the text parts of the message by the given text, keeping the other parts.
+func replaceText(msg *ai.Message, text string) {
+	content := make([]*ai.Part, 0, len(msg.Content))
+	replaced := false
+	for _, p := range msg.Content {
+		if !p.IsText() {
+			content = append(content, p)
+			continue
+		}
+		if !replaced {
+			content = append(content, ai.NewTextPart(text))
+			replaced = true
+		}
+	}
+	msg.Content = content
+}
+
+func addUsage(total *ai.GenerationUsage, usage *ai.GenerationUsage) *ai.GenerationUsage {
+	if usage == nil {
+		return total
+	}
+	if total == nil {
+		total = &ai.GenerationUsage{}
+	}
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	total.TotalTokens += usage.TotalTokens
+	total.InputCharacters += usage.InputCharacters
+	total.OutputCharacters += usage.OutputCharacters
+	return total
+}
@@ -0,0 +1,211 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+type person struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func answer(text string) *mistralclient.ChatCompletionResponse {
+	return &mistralclient.ChatCompletionResponse{
+		Choices: []mistralclient.ChatCompletionChoice{
+			{Message: mistralclient.NewAssistantMessageFromString(text)},
+		},
+		Usage: &mistralclient.UsageInfo{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+}
+
+func TestGenerateWithOutputRepair(t *testing.T) {
+	t.Run("should repair the output locally", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(answer("```json\n{\"name\": \"Bob\", \"age\": 42\n```"), nil).
+			Times(1)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithOutputRepair(2))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Who are you?"),
+			ai.WithOutputType(person{}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		var out person
+		assert.NoError(t, res.Output(&out))
+		assert.Equal(t, person{Name: "Bob", Age: 42}, out)
+		assert.Equal(t, 1, res.Message.Metadata[mistral.ResponseMetadataOutputAttempts])
+		assert.Equal(t, true, res.Message.Metadata[mistral.ResponseMetadataOutputRepaired])
+	})
+
+	t.Run("should ask the model again with the validation errors", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		gomock.InOrder(
+			mockClient.EXPECT().
+				ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(answer(`{"name": 42}`), nil),
+			mockClient.EXPECT().
+				ChatCompletion(
+					gomock.AssignableToTypeOf(ctxType),
+					gomock.Cond(func(x *mistralclient.ChatCompletionRequest) bool {
+						last := x.Messages[len(x.Messages)-1]
+						return assert.Equal(t, 3, len(x.Messages)) &&
+							assert.Equal(t, `{"name": 42}`, x.Messages[1].Content().String()) &&
+							assert.Contains(t, last.Content().String(), "Your previous answer is not valid") &&
+							assert.Contains(t, last.Content().String(), "age")
+					}),
+				).
+				Return(answer(`{"name": "Bob", "age": 42}`), nil),
+		)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithOutputRepair(2))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Who are you?"),
+			ai.WithOutputType(person{}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		var out person
+		assert.NoError(t, res.Output(&out))
+		assert.Equal(t, person{Name: "Bob", Age: 42}, out)
+		assert.Equal(t, 2, res.Message.Metadata[mistral.ResponseMetadataOutputAttempts])
+		assert.Equal(t, true, res.Message.Metadata[mistral.ResponseMetadataOutputRepaired])
+		assert.Equal(t, 30, res.Usage.TotalTokens)
+	})
+
+	t.Run("should give up after the maximum number of attempts", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(answer(`{"name": 42}`), nil).
+			Times(2)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithOutputRepair(1))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Who are you?"),
+			ai.WithOutputType(person{}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.Nil(t, res)
+		assert.ErrorContains(t, err, "did not match expected schema")
+	})
+
+	t.Run("should not validate the output when the repair is disabled", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			Return(answer(`{"name": "Bob", "age": 42}`), nil).
+			Times(1)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Who are you?"),
+			ai.WithOutputType(person{}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		assert.NoError(t, err)
+		assert.NotContains(t, res.Message.Metadata, mistral.ResponseMetadataOutputAttempts)
+	})
+
+	t.Run("should stream only the final output", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		setupListModelWithChatCompletion(mockClient)
+
+		stream := func(text string) <-chan *mistralclient.CompletionChunk {
+			chunks := make(chan *mistralclient.CompletionChunk, 1)
+			chunks <- &mistralclient.CompletionChunk{
+				Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString(text)}},
+			}
+			close(chunks)
+			return chunks
+		}
+		gomock.InOrder(
+			mockClient.EXPECT().
+				ChatCompletionStream(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(stream("not json"), nil),
+			mockClient.EXPECT().
+				ChatCompletionStream(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+				Return(stream(`{"name": "Bob", "age": 42}`), nil),
+		)
+
+		p := mistral.NewPlugin("fake", mistral.WithClient(mockClient), mistral.WithOutputRepair(2))
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		// When
+		var streamed string
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Who are you?"),
+			ai.WithOutputType(person{}),
+			ai.WithModelName("mistral/mistral-small-latest"),
+			ai.WithStreaming(func(_ context.Context, chunk *ai.ModelResponseChunk) error {
+				streamed += chunk.Text()
+				return nil
+			}))
+
+		// Then
+		assert.NoError(t, err)
+		assert.Equal(t, `{"name": "Bob", "age": 42}`, streamed)
+		assert.Equal(t, res.Text(), streamed)
+		assert.Equal(t, 2, res.Message.Metadata[mistral.ResponseMetadataOutputAttempts])
+	})
+}
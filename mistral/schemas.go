@@ -0,0 +1,66 @@
+package mistral
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
+)
+
+type schemasKey struct{}
+
+// withSchemas returns a context carrying the complete JSON schemas of a chat completion request.
+func withSchemas(ctx context.Context, schemas *mapping.Schemas) context.Context {
+	return context.WithValue(ctx, schemasKey{}, schemas)
+}
+
+func schemasFrom(ctx context.Context) *mapping.Schemas {
+	schemas, _ := ctx.Value(schemasKey{}).(*mapping.Schemas)
+	return schemas
+}
+
+// schemaTransport sends the complete JSON schemas of the chat completion requests, found in their context.
+// mistral-client only serializes the keywords of mistral.PropertyDefinition (type, description, properties
+// and default) and omits additionalProperties when false, so required, enum, items, anyOf
+// and the strict mode nullable unions would be lost otherwise. It also sends the specific function tool choices,
+// which mistral-client can't serialize.
+// When the request can't be patched, it is sent as serialized by mistral-client and a warning is logged.
+type schemaTransport struct {
+	base   http.RoundTripper
+	logger *slog.Logger
+}
+
+func (t *schemaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	schemas := schemasFrom(req.Context())
+	if schemas == nil || req.Body == nil || !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if patched, err := mapping.PatchSchemas(body, schemas); err != nil {
+		t.logger.WarnContext(req.Context(), "Complete JSON schemas not sent", slog.Any("error", err))
+	} else {
+		body = patched
+	}
+
+	// A round tripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,180 @@
+package mistral_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+)
+
+func TestGenerateWithCompleteSchemas(t *testing.T) {
+	t.Run("should send the normalized output and tool schemas as-is", func(t *testing.T) {
+		// Given
+		var sent struct {
+			ResponseFormat struct {
+				JsonSchema struct {
+					Schema map[string]any `json:"schema"`
+					Strict bool           `json:"strict"`
+				} `json:"json_schema"`
+			} `json:"response_format"`
+			Tools []struct {
+				Function struct {
+					Name       string         `json:"name"`
+					Parameters map[string]any `json:"parameters"`
+				} `json:"function"`
+			} `json:"tools"`
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(t, w, map[string]any{"object": "list", "data": []map[string]any{{
+				"id":           "mistral-small-latest",
+				"capabilities": map[string]any{"completion_chat": true, "function_calling": true},
+			}}})
+		})
+		mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+			writeJSON(t, w, map[string]any{
+				"id":    "cmpl-1",
+				"model": "mistral-small-latest",
+				"choices": []map[string]any{{
+					"index": 0,
+					"message": map[string]any{
+						"role":    "assistant",
+						"content": `{"status":"done","items":[{"name":"bread"}],"note":null}`,
+					},
+					"finish_reason": "stop",
+				}},
+			})
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		p := mistral.NewPlugin("fake", mistral.WithClientOptions(mistralclient.WithBaseApiUrl(server.URL)))
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		tool := genkit.DefineToolWithInputSchema(g, "weather", "Gives the weather", map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"unit": map[string]any{"type": "string", "enum": []any{"celsius", "fahrenheit"}},
+			},
+			"required": []any{"unit"},
+		}, func(ctx *ai.ToolContext, input any) (string, error) {
+			return "sunny", nil
+		})
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("Plan the shopping"),
+			ai.WithTools(tool),
+			ai.WithReturnToolRequests(true),
+			ai.WithOutputSchema(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"status": map[string]any{"type": "string", "enum": []any{"todo", "done"}},
+					"items":  map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/item"}},
+					"note":   map[string]any{"type": "string"},
+				},
+				"required": []any{"status", "items"},
+				"$defs": map[string]any{
+					"item": map[string]any{
+						"type":       "object",
+						"properties": map[string]any{"name": map[string]any{"type": "string"}},
+						"required":   []any{"name"},
+					},
+				},
+			}),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"status":"done","items":[{"name":"bread"}]}`, res.Text())
+
+		output := sent.ResponseFormat.JsonSchema.Schema
+		assert.True(t, sent.ResponseFormat.JsonSchema.Strict)
+		assert.Equal(t, false, output["additionalProperties"])
+		assert.ElementsMatch(t, []any{"status", "items", "note"}, output["required"])
+		properties := output["properties"].(map[string]any)
+		assert.Equal(t, []any{"todo", "done"}, properties["status"].(map[string]any)["enum"])
+		assert.Equal(t, []any{"string", "null"}, properties["note"].(map[string]any)["type"])
+		assert.Equal(t, map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{"name": map[string]any{"type": "string"}},
+			"required":             []any{"name"},
+			"additionalProperties": false,
+		}, properties["items"].(map[string]any)["items"])
+
+		require.Len(t, sent.Tools, 1)
+		parameters := sent.Tools[0].Function.Parameters
+		assert.Equal(t, []any{"unit"}, parameters["required"])
+		assert.Equal(t, []any{"celsius", "fahrenheit"},
+			parameters["properties"].(map[string]any)["unit"].(map[string]any)["enum"])
+	})
+	t.Run("should send the forced tool as a specific function tool choice", func(t *testing.T) {
+		// Given
+		var sent struct {
+			ToolChoice any `json:"tool_choice"`
+			Tools      []struct {
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			} `json:"tools"`
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(t, w, map[string]any{"object": "list", "data": []map[string]any{{
+				"id":           "mistral-small-latest",
+				"capabilities": map[string]any{"completion_chat": true, "function_calling": true},
+			}}})
+		})
+		mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+			writeJSON(t, w, map[string]any{
+				"id":    "cmpl-1",
+				"model": "mistral-small-latest",
+				"choices": []map[string]any{{
+					"index":         0,
+					"message":       map[string]any{"role": "assistant", "content": "Sunny"},
+					"finish_reason": "stop",
+				}},
+			})
+		})
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		p := mistral.NewPlugin("fake", mistral.WithClientOptions(mistralclient.WithBaseApiUrl(server.URL)))
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(p))
+
+		weather := genkit.DefineTool(g, "weather", "get the weather",
+			func(ctx *ai.ToolContext, input string) (string, error) { return "sunny", nil })
+		news := genkit.DefineTool(g, "news", "get the news",
+			func(ctx *ai.ToolContext, input string) (string, error) { return "nothing new", nil })
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What's the weather?"),
+			ai.WithTools(weather, news),
+			ai.WithToolChoice("weather"),
+			ai.WithReturnToolRequests(true),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": "weather"},
+		}, sent.ToolChoice)
+		require.Len(t, sent.Tools, 2)
+		assert.ElementsMatch(t, []string{"weather", "news"},
+			[]string{sent.Tools[0].Function.Name, sent.Tools[1].Function.Name})
+	})
+}
@@ -0,0 +1,202 @@
+package mistral
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/semantic"
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
+	"github.com/thomas-marquis/mistral-client/mistral"
+)
+
+const (
+	defaultSemanticEmbedder  = "mistral-embed"
+	defaultSemanticThreshold = 0.95
+
+	// ResponseMetadataCacheSimilarity is the response message metadata key holding the cosine similarity
+	// between the prompt and the cached one, when the response comes from the semantic cache.
+	ResponseMetadataCacheSimilarity = "cacheSimilarity"
+)
+
+// SemanticCachePolicy defines how the chat completions are cached by meaning: a request whose final user message
+// is similar enough to a cached one gets its response, without calling the model.
+//
+// Only the final user message is compared: the rest of the request (model, system prompt, history, tools and config)
+// must be identical. The prompts are embedded with a Mistral embedding model and indexed in memory.
+type SemanticCachePolicy struct {
+	// Embedder is the Mistral embedding model used to embed the prompts. Defaults to mistral-embed.
+	Embedder string
+
+	// Threshold is the minimum cosine similarity between two prompts to share a response. Defaults to 0.95.
+	Threshold float64
+
+	// Size is the maximum number of cached responses. The oldest ones are evicted first. Defaults to 1000.
+	Size int
+
+	// TTL is the duration the responses are kept. Zero means they never expire.
+	TTL time.Duration
+}
+
+// semanticCache looks up the responses of the prompts similar to the request one.
+// A nil semanticCache calls Mistral directly.
+type semanticCache struct {
+	client mistral.Client
+	policy SemanticCachePolicy
+	index  *semantic.Index
+	prices PriceTable
+	costs  *CostTracker
+	budget *budgetGuard
+	hooks  hooks
+	logger *slog.Logger
+}
+
+func newSemanticCache(
+	client mistral.Client, policy SemanticCachePolicy, prices PriceTable, costs *CostTracker, budget *budgetGuard,
+	h hooks, logger *slog.Logger,
+) *semanticCache {
+	if policy.Embedder == "" {
+		policy.Embedder = defaultSemanticEmbedder
+	}
+	if policy.Threshold == 0 {
+		policy.Threshold = defaultSemanticThreshold
+	}
+	if policy.Size <= 0 {
+		policy.Size = defaultCacheSize
+	}
+	return &semanticCache{
+		client: client,
+		policy: policy,
+		index:  semantic.NewIndex(policy.Size),
+		prices: prices,
+		costs:  costs,
+		budget: budget,
+		hooks:  h,
+		logger: logger,
+	}
+}
+
+// chat returns the cached response of the most similar prompt and its similarity, or calls complete
+// and caches its response. Requests which don't end with a user message are never cached.
+func (c *semanticCache) chat(
+	ctx context.Context, req *mistral.ChatCompletionRequest, bypass bool,
+	complete func() (*mistral.ChatCompletionResponse, error),
+) (*mistral.ChatCompletionResponse, float64, bool, error) {
+	if c == nil || bypass || len(req.Messages) == 0 {
+		resp, err := complete()
+		return resp, 0, false, err
+	}
+	last := len(req.Messages) - 1
+	user, ok := req.Messages[last].(*mistral.UserMessage)
+	if !ok {
+		resp, err := complete()
+		return resp, 0, false, err
+	}
+	prompt := contentText(user.MessageContent)
+	if prompt == "" {
+		resp, err := complete()
+		return resp, 0, false, err
+	}
+
+	scoped := *req
+	scoped.Stream = false
+	scoped.Messages = req.Messages[:last]
+	scope, err := cacheKey("semantic", chatKey(ctx, &scoped))
+	if err != nil {
+		c.logger.WarnContext(ctx, "Failed to compute the cache key", slog.Any("error", err))
+		resp, err := complete()
+		return resp, 0, false, err
+	}
+	vector, err := c.embed(ctx, prompt)
+	if err != nil {
+		c.logger.WarnContext(ctx, "Failed to embed the prompt for the semantic cache", slog.Any("error", err))
+		resp, err := complete()
+		return resp, 0, false, err
+	}
+
+	if cached, similarity, ok := c.index.Nearest(scope, vector); ok && similarity >= c.policy.Threshold {
+		var resp mistral.ChatCompletionResponse
+		if err := json.Unmarshal(cached.([]byte), &resp); err == nil {
+			c.logger.DebugContext(ctx, "Semantic cache hit",
+				slog.String("model", req.Model), slog.Float64("similarity", similarity))
+			return &resp, similarity, true, nil
+		}
+	}
+
+	resp, err := complete()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	// The response is stored encoded, as the hooks may modify the returned one.
+	if b, err := json.Marshal(resp); err == nil {
+		c.index.Add(scope, vector, b, c.policy.TTL)
+	}
+	return resp, 0, false, nil
+}
+
+// embed embeds the prompt, recording the cost of the embedding.
+// Like the embedders, the call is checked against the budgets and goes through the hooks.
+func (c *semanticCache) embed(ctx context.Context, prompt string) ([]float32, error) {
+	settle, err := c.budget.reserveRequest(ctx, c.policy.Embedder, c.prices, tokens.Text(prompt), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	call := &Call{
+		Operation:        OperationEmbeddings,
+		EmbedRequest:     &ai.EmbedRequest{Input: []*ai.Document{ai.DocumentFromText(prompt, nil)}},
+		EmbeddingRequest: mistral.NewEmbeddingRequest(c.policy.Embedder, []string{prompt}),
+	}
+	send := func(ctx context.Context, call *Call) error {
+		var err error
+		call.EmbeddingResponse, err = c.client.Embeddings(ctx, call.EmbeddingRequest)
+		return err
+	}
+	mapResponse := func(call *Call) error {
+		vectors := call.EmbeddingResponse.Embeddings()
+		if len(vectors) == 0 {
+			return fmt.Errorf("%w for the prompt", ErrNoEmbeddings)
+		}
+		call.EmbedResponse = &ai.EmbedResponse{Embeddings: []*ai.Embedding{{Embedding: vectors[0]}}}
+		return nil
+	}
+	if err := c.hooks.intercept(ctx, call, send, mapResponse); err != nil {
+		// A response without embeddings may still be billed, so its estimated cost is kept.
+		settle(0, !errors.Is(err, ErrNoEmbeddings))
+		return nil, err
+	}
+
+	if price, ok := c.prices.Lookup(c.policy.Embedder); ok {
+		cost := price.Cost(c.policy.Embedder, call.EmbeddingResponse.Usage.PromptTokens, 0)
+		if c.costs != nil {
+			c.costs.Record(ctx, cost)
+		}
+		settle(cost.Total, true)
+	}
+	return call.EmbedResponse.Embeddings[0].Embedding, nil
+}
+
+// contentText returns the text of a text-only content. Contents with other chunks (images, audio...)
+// return an empty string, as their text alone doesn't tell what they ask.
+func contentText(content mistral.Content) string {
+	switch c := content.(type) {
+	case mistral.ContentString:
+		return string(c)
+	case mistral.ContentChunks:
+		var sb strings.Builder
+		for _, chunk := range c {
+			text, ok := chunk.(*mistral.TextChunk)
+			if !ok {
+				return ""
+			}
+			sb.WriteString(text.Text)
+		}
+		return sb.String()
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGenerateWithSemanticCache(t *testing.T) {
+	vectors := map[string]mistralclient.EmbeddingVector{
+		"What is the capital of France?": {1, 0},
+		"What's the capital of France?":  {0.99, 0.1},
+		"How tall is the Eiffel tower?":  {0.2, 1},
+	}
+
+	setup := func(t *testing.T, completions int, opts ...mistral.Option) *genkit.Genkit {
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+
+		mockClient.EXPECT().
+			ListModels(gomock.Any()).
+			Return([]*mistralclient.BaseModelCard{
+				{Id: "mistral-small-latest", Capabilities: mistralclient.ModelCapabilities{CompletionChat: true}},
+				{Id: "mistral-embed", Capabilities: mistralclient.ModelCapabilities{}},
+			}, nil)
+
+		mockClient.EXPECT().
+			Embeddings(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *mistralclient.EmbeddingRequest) (*mistralclient.EmbeddingResponse, error) {
+				assert.Equal(t, "mistral-embed", req.Model)
+				return &mistralclient.EmbeddingResponse{
+					Data:  []mistralclient.EmbeddingData{{Embedding: vectors[req.Input[0]]}},
+					Usage: mistralclient.UsageInfo{PromptTokens: 1_000_000},
+				}, nil
+			}).
+			AnyTimes()
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.AssignableToTypeOf(ctxType), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req *mistralclient.ChatCompletionRequest) (*mistralclient.ChatCompletionResponse, error) {
+				return &mistralclient.ChatCompletionResponse{
+					Choices: []mistralclient.ChatCompletionChoice{
+						{Message: mistralclient.NewAssistantMessageFromString("Answer to " + req.Messages[len(req.Messages)-1].Content().String())},
+					},
+				}, nil
+			}).
+			Times(completions)
+
+		p := mistral.NewPlugin("fake", append([]mistral.Option{
+			mistral.WithClient(mockClient),
+			mistral.WithSemanticCache(mistral.SemanticCachePolicy{Threshold: 0.9}),
+		}, opts...)...)
+		return genkit.Init(context.Background(), genkit.WithPlugins(p))
+	}
+
+	t.Run("should answer similar prompts from the cache", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := setup(t, 2)
+		generate := func(prompt string) *ai.ModelResponse {
+			res, err := genkit.Generate(ctx, g,
+				ai.WithPrompt(prompt),
+				ai.WithModelName("mistral/mistral-small-latest"))
+			require.NoError(t, err)
+			return res
+		}
+		generate("What is the capital of France?")
+
+		// When
+		similar := generate("What's the capital of France?")
+		other := generate("How tall is the Eiffel tower?")
+
+		// Then
+		assert.Equal(t, "Answer to What is the capital of France?", similar.Text())
+		assert.Equal(t, true, similar.Message.Metadata[mistral.ResponseMetadataCacheHit])
+		assert.InDelta(t, 0.995, similar.Message.Metadata[mistral.ResponseMetadataCacheSimilarity], 0.001)
+		assert.Equal(t, "Answer to How tall is the Eiffel tower?", other.Text())
+		assert.NotContains(t, other.Message.Metadata, mistral.ResponseMetadataCacheHit)
+	})
+
+	t.Run("should not share responses between system prompts", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		g := setup(t, 2)
+		_, err := genkit.Generate(ctx, g,
+			ai.WithSystem("Answer in French."),
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+		require.NoError(t, err)
+
+		// When
+		res, err := genkit.Generate(ctx, g,
+			ai.WithSystem("Answer in English."),
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.NotContains(t, res.Message.Metadata, mistral.ResponseMetadataCacheHit)
+	})
+
+	t.Run("should embed the prompts through the hooks", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		var embedded []string
+		g := setup(t, 1, mistral.WithHooks(mistral.HookFuncs{
+			Before: func(_ context.Context, call *mistral.Call) error {
+				if call.Operation == mistral.OperationEmbeddings {
+					embedded = append(embedded, call.EmbeddingRequest.Input...)
+				}
+				return nil
+			},
+		}))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithPrompt("What is the capital of France?"),
+			ai.WithModelName("mistral/mistral-small-latest"))
+
+		// Then
+		require.NoError(t, err)
+		assert.Equal(t, []string{"What is the capital of France?"}, embedded)
+	})
+
+	t.Run("should check the budget before embedding the prompts", func(t *testing.T) {
+		// Given
+		ctx := context.Background()
+		store := mistral.NewMemoryBudgetStore()
+		var embedded []string
+		g := setup(t, 2,
+			// The completions are free and each embedding costs $1, so the budget only allows one embedding.
+			mistral.WithPrices(mistral.PriceTable{"mistral-small": {}, "mistral-embed": {Input: 1}}),
+			mistral.WithBudget(mistral.BudgetPolicy{
+				Budgets: []mistral.Budget{{Limit: 1, Scope: mistral.BudgetScopeTenant}},
+				Store:   store,
+			}),
+			mistral.WithHooks(mistral.HookFuncs{
+				Before: func(_ context.Context, call *mistral.Call) error {
+					if call.Operation == mistral.OperationEmbeddings {
+						embedded = append(embedded, call.EmbeddingRequest.Input...)
+					}
+					return nil
+				},
+			}))
+		generate := func(prompt string) *ai.ModelResponse {
+			res, err := genkit.Generate(ctx, g,
+				ai.WithPrompt(prompt),
+				ai.WithModelName("mistral/mistral-small-latest"))
+			require.NoError(t, err)
+			return res
+		}
+
+		// When
+		generate("What is the capital of France?")
+		res := generate("How tall is the Eiffel tower?")
+
+		// Then
+		assert.Equal(t, "Answer to How tall is the Eiffel tower?", res.Text())
+		assert.Equal(t, []string{"What is the capital of France?"}, embedded)
+		// Without tenant, the tenant budget is stored under "tenant:".
+		spent, err := store.Spent(ctx, "tenant:")
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, spent)
+	})
+}
@@ -0,0 +1,351 @@
+package mistral
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/thomas-marquis/mistral-client/mistral"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/thomas-marquis/genkit-mistral/mistral"
+
+const (
+	// AttributeToolCallCount is the span attribute holding the number of tool calls of a chat completion.
+	AttributeToolCallCount = attribute.Key("mistral.response.tool_call_count")
+
+	// MetricTimeToFirstToken is the histogram of the time elapsed before receiving the first chunk of a stream, in seconds.
+	MetricTimeToFirstToken = "mistral.client.time_to_first_token"
+
+	// MetricErrors is the counter of failed calls, by error.type.
+	MetricErrors = "mistral.client.errors"
+)
+
+// systemKey is the attribute replaced by gen_ai.provider.name in the latest conventions,
+// still read by most GenAI observability tools.
+var systemKey = attribute.Key("gen_ai.system")
+
+// Bucket boundaries recommended by the GenAI semantic conventions.
+var (
+	tokenBuckets    = []float64{1, 4, 16, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864}
+	durationBuckets = []float64{0.01, 0.02, 0.04, 0.08, 0.16, 0.32, 0.64, 1.28, 2.56, 5.12, 10.24, 20.48, 40.96, 81.92}
+)
+
+// instrumentedClient wraps the chat completion and embedding calls
+// in spans and metrics following the OpenTelemetry GenAI semantic conventions.
+type instrumentedClient struct {
+	mistral.Client
+
+	tracer           trace.Tracer
+	tokenUsage       metric.Int64Histogram
+	duration         metric.Float64Histogram
+	timeToFirstToken metric.Float64Histogram
+	errors           metric.Int64Counter
+}
+
+var _ mistral.Client = &instrumentedClient{}
+
+// NewInstrumentedClient wraps the client so that its chat completion and embedding calls
+// create spans and record metrics following the OpenTelemetry GenAI semantic conventions.
+// The plugin instruments its client this way; use it when calling the Mistral client directly.
+// Nil providers are replaced by the global ones.
+func NewInstrumentedClient(c mistral.Client, tp trace.TracerProvider, mp metric.MeterProvider) mistral.Client {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(instrumentationName)
+
+	ic := &instrumentedClient{
+		Client: c,
+		tracer: tp.Tracer(instrumentationName),
+	}
+
+	var err error
+	ic.tokenUsage, err = meter.Int64Histogram("gen_ai.client.token.usage",
+		metric.WithDescription("Number of input and output tokens used."),
+		metric.WithUnit("{token}"),
+		metric.WithExplicitBucketBoundaries(tokenBuckets...))
+	if err != nil {
+		otel.Handle(err)
+	}
+	ic.duration, err = meter.Float64Histogram("gen_ai.client.operation.duration",
+		metric.WithDescription("GenAI operation duration."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	if err != nil {
+		otel.Handle(err)
+	}
+	ic.timeToFirstToken, err = meter.Float64Histogram(MetricTimeToFirstToken,
+		metric.WithDescription("Time to receive the first chunk of a streamed completion."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...))
+	if err != nil {
+		otel.Handle(err)
+	}
+	ic.errors, err = meter.Int64Counter(MetricErrors,
+		metric.WithDescription("Number of failed calls to Mistral."),
+		metric.WithUnit("{error}"))
+	if err != nil {
+		otel.Handle(err)
+	}
+
+	return ic
+}
+
+func (c *instrumentedClient) ChatCompletion(ctx context.Context, req *mistral.ChatCompletionRequest) (*mistral.ChatCompletionResponse, error) {
+	ctx, span := c.startChat(ctx, req)
+	defer span.End()
+	start := time.Now()
+
+	resp, err := c.Client.ChatCompletion(ctx, req)
+	if err != nil {
+		c.recordError(ctx, span, semconv.GenAIOperationNameChat, req.Model, start, err)
+		return nil, err
+	}
+
+	var finishReasons []string
+	var toolCalls int
+	for _, choice := range resp.Choices {
+		finishReasons = append(finishReasons, string(choice.FinishReason))
+		if choice.Message != nil {
+			toolCalls += len(choice.Message.ToolCalls)
+		}
+	}
+	c.recordResponse(ctx, span, semconv.GenAIOperationNameChat, req.Model, start,
+		resp.Id, resp.Model, resp.Usage, finishReasons, toolCalls)
+
+	return resp, nil
+}
+
+func (c *instrumentedClient) ChatCompletionStream(ctx context.Context, req *mistral.ChatCompletionRequest) (<-chan *mistral.CompletionChunk, error) {
+	ctx, span := c.startChat(ctx, req)
+	start := time.Now()
+
+	chunks, err := c.Client.ChatCompletionStream(ctx, req)
+	if err != nil {
+		c.recordError(ctx, span, semconv.GenAIOperationNameChat, req.Model, start, err)
+		span.End()
+		return nil, err
+	}
+
+	out := make(chan *mistral.CompletionChunk)
+	go func() {
+		defer close(out)
+		defer span.End()
+
+		// send forwards a chunk, giving up when the context is done: the consumer may have stopped reading.
+		send := func(chunk *mistral.CompletionChunk) bool {
+			select {
+			case out <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var (
+			id, model     string
+			usage         *mistral.UsageInfo
+			finishReasons []string
+			toolCalls     int
+			first         = true
+		)
+		for chunk := range chunks {
+			if first {
+				first = false
+				c.timeToFirstToken.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+					semconv.GenAIOperationNameChat,
+					semconv.GenAIProviderNameMistralAI,
+					semconv.GenAIRequestModel(req.Model)))
+			}
+			if chunk.Error != nil {
+				c.recordError(ctx, span, semconv.GenAIOperationNameChat, req.Model, start, chunk.Error)
+				if !send(chunk) {
+					return
+				}
+				// The stream is over: the remaining chunks are only forwarded.
+				for chunk := range chunks {
+					if !send(chunk) {
+						return
+					}
+				}
+				return
+			}
+
+			if chunk.Id != "" {
+				id = chunk.Id
+			}
+			if chunk.Model != "" {
+				model = chunk.Model
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+			}
+			for _, choice := range chunk.Choices {
+				if choice.FinishReason != "" {
+					finishReasons = append(finishReasons, string(choice.FinishReason))
+				}
+				if choice.Delta != nil {
+					toolCalls += len(choice.Delta.ToolCalls)
+				}
+			}
+			if !send(chunk) {
+				c.recordError(ctx, span, semconv.GenAIOperationNameChat, req.Model, start, ctx.Err())
+				return
+			}
+		}
+		c.recordResponse(ctx, span, semconv.GenAIOperationNameChat, req.Model, start,
+			id, model, usage, finishReasons, toolCalls)
+	}()
+
+	return out, nil
+}
+
+func (c *instrumentedClient) Embeddings(ctx context.Context, req *mistral.EmbeddingRequest) (*mistral.EmbeddingResponse, error) {
+	ctx, span := c.tracer.Start(ctx, "embeddings "+req.Model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.GenAIOperationNameEmbeddings,
+			semconv.GenAIProviderNameMistralAI,
+			systemKey.String(semconv.GenAIProviderNameMistralAI.Value.AsString()),
+			semconv.GenAIRequestModel(req.Model),
+		))
+	defer span.End()
+	start := time.Now()
+
+	resp, err := c.Client.Embeddings(ctx, req)
+	if err != nil {
+		c.recordError(ctx, span, semconv.GenAIOperationNameEmbeddings, req.Model, start, err)
+		return nil, err
+	}
+
+	usage := resp.Usage
+	c.recordResponse(ctx, span, semconv.GenAIOperationNameEmbeddings, req.Model, start,
+		resp.ID, resp.Model, &usage, nil, 0)
+
+	return resp, nil
+}
+
+func (c *instrumentedClient) startChat(ctx context.Context, req *mistral.ChatCompletionRequest) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		semconv.GenAIOperationNameChat,
+		semconv.GenAIProviderNameMistralAI,
+		systemKey.String(semconv.GenAIProviderNameMistralAI.Value.AsString()),
+		semconv.GenAIRequestModel(req.Model),
+	}
+	if req.MaxTokens > 0 {
+		attrs = append(attrs, semconv.GenAIRequestMaxTokens(req.MaxTokens))
+	}
+	if req.Temperature != 0 {
+		attrs = append(attrs, semconv.GenAIRequestTemperature(req.Temperature))
+	}
+	if req.TopP != 0 {
+		attrs = append(attrs, semconv.GenAIRequestTopP(req.TopP))
+	}
+	if req.RandomSeed != 0 {
+		attrs = append(attrs, semconv.GenAIRequestSeed(req.RandomSeed))
+	}
+	if len(req.Stop) > 0 {
+		attrs = append(attrs, semconv.GenAIRequestStopSequences(req.Stop...))
+	}
+
+	return c.tracer.Start(ctx, "chat "+req.Model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+}
+
+func (c *instrumentedClient) recordResponse(
+	ctx context.Context,
+	span trace.Span,
+	operation attribute.KeyValue,
+	requestModel string,
+	start time.Time,
+	id, responseModel string,
+	usage *mistral.UsageInfo,
+	finishReasons []string,
+	toolCalls int,
+) {
+	metricAttrs := []attribute.KeyValue{
+		operation,
+		semconv.GenAIProviderNameMistralAI,
+		semconv.GenAIRequestModel(requestModel),
+	}
+	if responseModel != "" {
+		metricAttrs = append(metricAttrs, semconv.GenAIResponseModel(responseModel))
+	}
+	c.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(metricAttrs...))
+
+	if id != "" {
+		span.SetAttributes(semconv.GenAIResponseID(id))
+	}
+	if responseModel != "" {
+		span.SetAttributes(semconv.GenAIResponseModel(responseModel))
+	}
+	if len(finishReasons) > 0 {
+		span.SetAttributes(semconv.GenAIResponseFinishReasons(finishReasons...))
+	}
+	if operation == semconv.GenAIOperationNameChat {
+		span.SetAttributes(AttributeToolCallCount.Int(toolCalls))
+	}
+	if usage != nil {
+		span.SetAttributes(
+			semconv.GenAIUsageInputTokens(usage.PromptTokens),
+			semconv.GenAIUsageOutputTokens(usage.CompletionTokens))
+
+		c.tokenUsage.Record(ctx, int64(usage.PromptTokens),
+			metric.WithAttributes(append(metricAttrs, semconv.GenAITokenTypeInput)...))
+		if operation == semconv.GenAIOperationNameChat {
+			c.tokenUsage.Record(ctx, int64(usage.CompletionTokens),
+				metric.WithAttributes(append(metricAttrs, semconv.GenAITokenTypeOutput)...))
+		}
+	}
+}
+
+func (c *instrumentedClient) recordError(
+	ctx context.Context,
+	span trace.Span,
+	operation attribute.KeyValue,
+	requestModel string,
+	start time.Time,
+	err error,
+) {
+	errType := semconv.ErrorTypeKey.String(errorType(err))
+	attrs := []attribute.KeyValue{
+		operation,
+		semconv.GenAIProviderNameMistralAI,
+		semconv.GenAIRequestModel(requestModel),
+		errType,
+	}
+	c.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+	c.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+
+	span.SetAttributes(errType)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// errorType returns the HTTP status code of the API errors,
+// "timeout" and "canceled" for the context errors, and the Go type of the other ones.
+func errorType(err error) string {
+	var apiErr mistral.ApiError
+	switch {
+	case errors.As(err, &apiErr):
+		return strconv.Itoa(apiErr.Code())
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return semconv.ErrorType(err).Value.AsString()
+	}
+}
@@ -0,0 +1,331 @@
+package mistral_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thomas-marquis/genkit-mistral/mistral"
+	"github.com/thomas-marquis/genkit-mistral/mocks"
+	mistralclient "github.com/thomas-marquis/mistral-client/mistral"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/mock/gomock"
+)
+
+type telemetry struct {
+	spans   *tracetest.InMemoryExporter
+	metrics *sdkmetric.ManualReader
+	tp      *sdktrace.TracerProvider
+	mp      *sdkmetric.MeterProvider
+}
+
+func newTelemetry() *telemetry {
+	spans := tracetest.NewInMemoryExporter()
+	metrics := sdkmetric.NewManualReader()
+	return &telemetry{
+		spans:   spans,
+		metrics: metrics,
+		tp:      sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)),
+		mp:      sdkmetric.NewMeterProvider(sdkmetric.WithReader(metrics)),
+	}
+}
+
+func (tel *telemetry) span(t *testing.T) tracetest.SpanStub {
+	t.Helper()
+	spans := tel.spans.GetSpans()
+	require.Len(t, spans, 1)
+	return spans[0]
+}
+
+func (tel *telemetry) metric(t *testing.T, name string) metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, tel.metrics.Collect(context.Background(), &rm))
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m.Data
+			}
+		}
+	}
+	require.Failf(t, "metric not found", "%s", name)
+	return nil
+}
+
+func attributes(kvs []attribute.KeyValue) map[attribute.Key]any {
+	res := make(map[attribute.Key]any, len(kvs))
+	for _, kv := range kvs {
+		res[kv.Key] = kv.Value.AsInterface()
+	}
+	return res
+}
+
+func TestTelemetry(t *testing.T) {
+	t.Run("should trace a chat completion with the GenAI conventions", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+		setupListModelWithChatCompletion(mockClient)
+		tel := newTelemetry()
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.Any(), gomock.Any()).
+			Return(&mistralclient.ChatCompletionResponse{
+				Id:    "resp-1",
+				Model: "mistral-large-2411",
+				Choices: []mistralclient.ChatCompletionChoice{{
+					FinishReason: mistralclient.FinishReasonToolCalls,
+					Message: mistralclient.NewAssistantMessage(mistralclient.ContentString(""),
+						mistralclient.NewToolCall("call1", 0, "search", map[string]any{"q": "a"}),
+						mistralclient.NewToolCall("call2", 1, "search", map[string]any{"q": "b"})),
+				}},
+				Usage: &mistralclient.UsageInfo{PromptTokens: 12, CompletionTokens: 7, TotalTokens: 19},
+			}, nil)
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithTracerProvider(tel.tp),
+			mistral.WithMeterProvider(tel.mp),
+		)))
+
+		// When
+		_, err := genkit.Generate(ctx, g,
+			ai.WithModelName("mistral/mistral-small-latest"),
+			ai.WithPrompt("Search something"),
+			ai.WithConfig(mistralclient.CompletionConfig{MaxTokens: 100}),
+			ai.WithReturnToolRequests(true))
+
+		// Then
+		require.NoError(t, err)
+
+		span := tel.span(t)
+		assert.Equal(t, "chat mistral-small-latest", span.Name)
+		attrs := attributes(span.Attributes)
+		assert.Equal(t, "chat", attrs["gen_ai.operation.name"])
+		assert.Equal(t, "mistral_ai", attrs["gen_ai.system"])
+		assert.Equal(t, "mistral_ai", attrs["gen_ai.provider.name"])
+		assert.Equal(t, "mistral-small-latest", attrs["gen_ai.request.model"])
+		assert.Equal(t, int64(100), attrs["gen_ai.request.max_tokens"])
+		assert.Equal(t, "resp-1", attrs["gen_ai.response.id"])
+		assert.Equal(t, "mistral-large-2411", attrs["gen_ai.response.model"])
+		assert.Equal(t, int64(12), attrs["gen_ai.usage.input_tokens"])
+		assert.Equal(t, int64(7), attrs["gen_ai.usage.output_tokens"])
+		assert.Equal(t, []string{"tool_calls"}, attrs["gen_ai.response.finish_reasons"])
+		assert.Equal(t, int64(2), attrs[mistral.AttributeToolCallCount])
+
+		usage := tel.metric(t, "gen_ai.client.token.usage").(metricdata.Histogram[int64])
+		require.Len(t, usage.DataPoints, 2)
+		for _, dp := range usage.DataPoints {
+			tokenType, _ := dp.Attributes.Value("gen_ai.token.type")
+			model, _ := dp.Attributes.Value("gen_ai.response.model")
+			assert.Equal(t, "mistral-large-2411", model.AsString())
+			assert.Equal(t, uint64(1), dp.Count)
+			switch tokenType.AsString() {
+			case "input":
+				assert.Equal(t, int64(12), dp.Sum)
+			case "output":
+				assert.Equal(t, int64(7), dp.Sum)
+			default:
+				t.Errorf("unexpected token type %q", tokenType.AsString())
+			}
+		}
+
+		duration := tel.metric(t, "gen_ai.client.operation.duration").(metricdata.Histogram[float64])
+		require.Len(t, duration.DataPoints, 1)
+		assert.Equal(t, uint64(1), duration.DataPoints[0].Count)
+	})
+
+	t.Run("should count errors by type", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+		setupListModelWithChatCompletion(mockClient)
+		tel := newTelemetry()
+
+		mockClient.EXPECT().
+			ChatCompletion(gomock.Any(), gomock.Any()).
+			Return(nil, mistralclient.NewApiError(429, map[string]any{"message": "Requests rate limit exceeded"})).
+			Times(2)
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithTracerProvider(tel.tp),
+			mistral.WithMeterProvider(tel.mp),
+		)))
+
+		// When
+		for range 2 {
+			_, err := genkit.Generate(ctx, g,
+				ai.WithModelName("mistral/mistral-small-latest"),
+				ai.WithPrompt("Hello"))
+			require.Error(t, err)
+		}
+
+		// Then
+		spans := tel.spans.GetSpans()
+		require.Len(t, spans, 2)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Equal(t, "429", attributes(spans[0].Attributes)["error.type"])
+		require.Len(t, spans[0].Events, 1)
+		assert.Equal(t, "exception", spans[0].Events[0].Name)
+
+		errs := tel.metric(t, mistral.MetricErrors).(metricdata.Sum[int64])
+		require.Len(t, errs.DataPoints, 1)
+		assert.Equal(t, int64(2), errs.DataPoints[0].Value)
+		errType, _ := errs.DataPoints[0].Attributes.Value("error.type")
+		assert.Equal(t, "429", errType.AsString())
+	})
+
+	t.Run("should trace embeddings", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+		setupListModelWithEmbedding(mockClient)
+		tel := newTelemetry()
+
+		mockClient.EXPECT().
+			Embeddings(gomock.Any(), gomock.Any()).
+			Return(&mistralclient.EmbeddingResponse{
+				ID:    "emb-1",
+				Model: "mistral-embed",
+				Usage: mistralclient.UsageInfo{PromptTokens: 4, TotalTokens: 4},
+				Data:  []mistralclient.EmbeddingData{{Embedding: []float32{1, 2, 3}}},
+			}, nil)
+
+		ctx := context.Background()
+		g := genkit.Init(ctx, genkit.WithPlugins(mistral.NewPlugin("fake",
+			mistral.WithClient(mockClient),
+			mistral.WithTracerProvider(tel.tp),
+			mistral.WithMeterProvider(tel.mp),
+		)))
+
+		// When
+		_, err := genkit.Embed(ctx, g,
+			ai.WithDocs(ai.DocumentFromText("Hello, World!", nil)),
+			ai.WithEmbedderName("mistral/mistral-embed"))
+
+		// Then
+		require.NoError(t, err)
+
+		span := tel.span(t)
+		assert.Equal(t, "embeddings mistral-embed", span.Name)
+		attrs := attributes(span.Attributes)
+		assert.Equal(t, "embeddings", attrs["gen_ai.operation.name"])
+		assert.Equal(t, int64(4), attrs["gen_ai.usage.input_tokens"])
+		assert.NotContains(t, attrs, mistral.AttributeToolCallCount)
+
+		usage := tel.metric(t, "gen_ai.client.token.usage").(metricdata.Histogram[int64])
+		require.Len(t, usage.DataPoints, 1)
+		assert.Equal(t, int64(4), usage.DataPoints[0].Sum)
+	})
+
+	t.Run("should trace a streamed completion and its time to first token", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+		tel := newTelemetry()
+
+		chunks := make(chan *mistralclient.CompletionChunk, 2)
+		chunks <- &mistralclient.CompletionChunk{
+			Id:      "resp-1",
+			Model:   "mistral-small-2503",
+			Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString("Hel")}},
+		}
+		chunks <- &mistralclient.CompletionChunk{
+			Id:    "resp-1",
+			Model: "mistral-small-2503",
+			Choices: []mistralclient.CompletionResponseStreamChoice{{
+				Delta:        mistralclient.NewAssistantMessageFromString("lo"),
+				FinishReason: mistralclient.FinishReasonStop,
+			}},
+			Usage: &mistralclient.UsageInfo{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7},
+		}
+		close(chunks)
+
+		mockClient.EXPECT().
+			ChatCompletionStream(gomock.Any(), gomock.Any()).
+			Return((<-chan *mistralclient.CompletionChunk)(chunks), nil)
+
+		client := mistral.NewInstrumentedClient(mockClient, tel.tp, tel.mp)
+
+		// When
+		out, err := client.ChatCompletionStream(context.Background(), &mistralclient.ChatCompletionRequest{
+			Model: "mistral-small-latest",
+			CompletionConfig: mistralclient.CompletionConfig{
+				Stream: true,
+			},
+		})
+
+		// Then
+		require.NoError(t, err)
+		var received int
+		for range out {
+			received++
+		}
+		assert.Equal(t, 2, received)
+
+		span := tel.span(t)
+		attrs := attributes(span.Attributes)
+		assert.Equal(t, "mistral-small-2503", attrs["gen_ai.response.model"])
+		assert.Equal(t, []string{"stop"}, attrs["gen_ai.response.finish_reasons"])
+		assert.Equal(t, int64(5), attrs["gen_ai.usage.input_tokens"])
+		assert.Equal(t, int64(2), attrs["gen_ai.usage.output_tokens"])
+
+		ttft := tel.metric(t, mistral.MetricTimeToFirstToken).(metricdata.Histogram[float64])
+		require.Len(t, ttft.DataPoints, 1)
+		assert.Equal(t, uint64(1), ttft.DataPoints[0].Count)
+	})
+
+	t.Run("should end the span of a stream abandoned by its consumer", func(t *testing.T) {
+		// Given
+		ctrl := gomock.NewController(t)
+		mockClient := mocks.NewMockClient(ctrl)
+		tel := newTelemetry()
+
+		chunks := make(chan *mistralclient.CompletionChunk, 2)
+		chunks <- &mistralclient.CompletionChunk{
+			Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString("Hel")}},
+		}
+		chunks <- &mistralclient.CompletionChunk{
+			Choices: []mistralclient.CompletionResponseStreamChoice{{Delta: mistralclient.NewAssistantMessageFromString("lo")}},
+		}
+
+		mockClient.EXPECT().
+			ChatCompletionStream(gomock.Any(), gomock.Any()).
+			Return((<-chan *mistralclient.CompletionChunk)(chunks), nil)
+
+		client := mistral.NewInstrumentedClient(mockClient, tel.tp, tel.mp)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		out, err := client.ChatCompletionStream(ctx, &mistralclient.ChatCompletionRequest{
+			Model:            "mistral-small-latest",
+			CompletionConfig: mistralclient.CompletionConfig{Stream: true},
+		})
+		require.NoError(t, err)
+		<-out
+
+		// When
+		cancel()
+
+		// Then
+		require.Eventually(t, func() bool {
+			return len(tel.spans.GetSpans()) == 1
+		}, time.Second, 10*time.Millisecond)
+		_, open := <-out
+		assert.False(t, open)
+		span := tel.span(t)
+		assert.Equal(t, codes.Error, span.Status.Code)
+		assert.Equal(t, "canceled", attributes(span.Attributes)["error.type"])
+	})
+}
@@ -1,76 +1,39 @@
 package mistral
 
 import (
-	"log"
-	"os"
 	"strings"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/thomas-marquis/genkit-mistral/mistral/internal/mapping"
-)
-
-var (
-	logger = log.New(os.Stdout, "mistral-client: ", log.LstdFlags|log.Lshortfile)
+	"github.com/thomas-marquis/genkit-mistral/mistral/internal/tokens"
 )
 
 // StringFromParts returns the content of a multi-parts message as a string.
 // The multiple parts are concatenated with a newline character.
-func StringFromParts(content []*ai.Part) string {
-	msg, err := mapping.StringFromParts(content)
-	if err != nil {
-		logger.Printf("Failed to convert message content to string: %v\n", err)
-	}
-
-	return msg
+// An error is returned when the content holds non-text parts.
+func StringFromParts(content []*ai.Part) (string, error) {
+	return mapping.StringFromParts(content)
 }
 
 // SanitizeToolName formats a function name to be used as a reference in a tool call.
 func SanitizeToolName(name string) string {
-	runes := []rune(name)
-
-	isAllowed := func(r rune) bool {
-		return (r >= 'a' && r <= 'z') ||
-			(r >= 'A' && r <= 'Z') ||
-			(r >= '0' && r <= '9') ||
-			r == '_' || r == '-'
-	}
+	return mapping.SanitizeToolName(name)
+}
 
-	var b strings.Builder
-	b.Grow(len(runes))
+// MessageMetadataPrefix is the message metadata key flagging the last model message as the beginning of the response.
+const MessageMetadataPrefix = mapping.MetadataPrefix
 
-	for i := 0; i < len(runes); {
-		r := runes[i]
-		switch {
-		case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_':
-			b.WriteRune(r)
-			i++
-		case r == '-':
-			// If pattern is "-<disallowed>-" convert to "_-" (skip the middle disallowed runes)
-			j := i + 1
-			skipped := 0
-			for j < len(runes) && !isAllowed(runes[j]) {
-				j++
-				skipped++
-			}
-			if j < len(runes) && runes[j] == '-' && skipped > 0 {
-				b.WriteRune('_')
-				b.WriteRune('-')
-				i = j + 1
-			} else {
-				b.WriteRune('-')
-				i++
-			}
-		default:
-			// drop any other disallowed characters
-			i++
-		}
-	}
+// NewPrefixMessage returns a model message the response must start with.
+// It must be the last message of the request. The returned response text includes the prefix.
+func NewPrefixMessage(text string) *ai.Message {
+	return ai.NewMessage(ai.RoleModel, map[string]any{MessageMetadataPrefix: true}, ai.NewTextPart(text))
+}
 
-	result := b.String()
-	if len(result) > 256 {
-		result = result[:256]
-	}
-	return result
+// CountTokens estimates, without calling the API, the number of prompt tokens the request would use with the model.
+// Messages, tool definitions, output schema, images and control tokens are taken into account.
+// The estimation approximates Mistral tokenizers, expect a difference of a few percents with the reported usage.
+func CountTokens(model string, mr *ai.ModelRequest) int {
+	return tokens.ForModel(model).Request(mr)
 }
 
 func mapResponseFromText(mr *ai.ModelRequest, resp string) *ai.ModelResponse {
@@ -82,3 +45,39 @@ func mapResponseFromText(mr *ai.ModelRequest, resp string) *ai.ModelResponse {
 		},
 	}
 }
+
+// textFromMessages returns the text parts of the messages with the given role, joined with a newline.
+// When lastOnly is true, only the last message with this role is considered.
+func textFromMessages(messages []*ai.Message, role ai.Role, lastOnly bool) string {
+	var texts []string
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if msg.Role != role {
+			continue
+		}
+		var parts []string
+		for _, part := range msg.Content {
+			if part.IsText() {
+				parts = append(parts, part.Text)
+			}
+		}
+		texts = append([]string{strings.Join(parts, "\n")}, texts...)
+		if lastOnly {
+			break
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// setEstimatedUsage fills the response usage with locally estimated token counts,
+// the same way MapToGenkitResponse does with the usage reported by Mistral.
+func setEstimatedUsage(resp *ai.ModelResponse) {
+	inputTokens := tokens.Request(resp.Request)
+	outputTokens := tokens.Message(resp.Message)
+	resp.Usage = &ai.GenerationUsage{
+		InputTokens:  inputTokens,
+		OutputTokens: outputTokens,
+		TotalTokens:  inputTokens + outputTokens,
+	}
+	ai.CalculateInputOutputUsage(resp.Request, resp)
+}
@@ -4,6 +4,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/firebase/genkit/go/ai"
 	"github.com/stretchr/testify/assert"
 	"github.com/thomas-marquis/genkit-mistral/mistral"
 )
@@ -74,3 +75,49 @@ func Test_SanitizeToolName_ShouldRemoveUnicode_WhenNonAsciiLetters(t *testing.T)
 	// Then
 	assert.Equal(t, "Caf_t_-", got)
 }
+
+func Test_StringFromParts_ShouldJoinTextParts_WhenTextOnly(t *testing.T) {
+	// Given
+	content := []*ai.Part{ai.NewTextPart("Hello"), ai.NewTextPart("World")}
+
+	// When
+	got, err := mistral.StringFromParts(content)
+
+	// Then
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello\nWorld", got)
+}
+
+func Test_StringFromParts_ShouldReturnError_WhenNonTextPart(t *testing.T) {
+	// Given
+	content := []*ai.Part{ai.NewTextPart("Hello"), ai.NewMediaPart("image/png", "data:image/png;base64,AAAA")}
+
+	// When
+	_, err := mistral.StringFromParts(content)
+
+	// Then
+	assert.Error(t, err)
+}
+
+func Test_CountTokens_ShouldEstimatePromptTokens_WhenTextRequest(t *testing.T) {
+	// Given
+	mr := &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("What is the capital of France?")}}
+
+	// When
+	got := mistral.CountTokens("mistral-small-latest", mr)
+
+	// Then
+	assert.Equal(t, 10, got)
+}
+
+func Test_CountTokens_ShouldDependOnTheModelTokenizer_WhenLongWords(t *testing.T) {
+	// Given
+	mr := &ai.ModelRequest{Messages: []*ai.Message{ai.NewUserTextMessage("internationalization")}}
+
+	// When
+	tekken := mistral.CountTokens("mistral-small-latest", mr)
+	sentencePiece := mistral.CountTokens("open-mistral-7b", mr)
+
+	// Then
+	assert.Less(t, tekken, sentencePiece)
+}